@@ -0,0 +1,251 @@
+package cache
+
+import (
+	"bytes"
+	"distributed_cache/common"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ghost keeps the size of the evicted value only
+type ghost int
+
+func (g ghost) NBytes() int {
+	return int(g)
+}
+
+func (g ghost) Bytes() []byte {
+	return nil
+}
+
+func (g ghost) String() string {
+	return fmt.Sprintf("ghost(%d)", int(g))
+}
+
+// ARC is the adaptive replacement cache:
+// t1 holds the entries seen once recently, t2 the entries seen at least twice,
+// b1 and b2 remember the keys evicted from them, a hit on b1 grows the
+// target bytes of t1 and a hit on b2 shrinks it, so the cache tunes itself
+// between the recency and the frequency, all sizes are counted in bytes
+type ARC struct {
+	maxBytes int64
+	p        int64 // target bytes of t1
+	t1       *LRU
+	t2       *LRU
+	b1       *LRU // ghosts of t1
+	b2       *LRU // ghosts of t2
+	list     map[string]*LRU
+	counters
+	sync.Mutex
+}
+
+func NewARC(maxBytes int64) (*ARC, error) {
+	if maxBytes <= 0 {
+		return &ARC{}, common.ErrPositiveParamNegative
+	}
+	a := &ARC{
+		maxBytes: maxBytes,
+		list:     make(map[string]*LRU),
+	}
+	// the lists never evict by themselves
+	a.t1, _ = NewLRU(math.MaxInt64)
+	a.t2, _ = NewLRU(math.MaxInt64)
+	a.b1, _ = NewLRU(math.MaxInt64)
+	a.b2, _ = NewLRU(math.MaxInt64)
+	return a, nil
+}
+
+func (a *ARC) remove(key string) {
+	a.list[key].remove(key)
+	delete(a.list, key)
+}
+
+func (a *ARC) resident(key string) bool {
+	l, ok := a.list[key]
+	return ok && (l == a.t1 || l == a.t2)
+}
+
+// move the lru entry of the list to its ghost list
+func (a *ARC) demote(from *LRU, to *LRU) {
+	victim := from.getVictim()
+	from.remove(victim.key)
+	to.put(victim.key, ghost(victim.value.NBytes()), time.Time{})
+	a.list[victim.key] = to
+	a.evictions++
+}
+
+// evict the resident entries until the entry of size fits
+func (a *ARC) replace(size int64, inB2 bool) {
+	for a.t1.nbytes+a.t2.nbytes+size > a.maxBytes {
+		if !a.t1.IsEmpty() && (a.t1.nbytes > a.p || (inB2 && a.t1.nbytes == a.p) || a.t2.IsEmpty()) {
+			a.demote(a.t1, a.b1)
+		} else {
+			a.demote(a.t2, a.b2)
+		}
+	}
+}
+
+// drop the oldest ghosts of the list
+func (a *ARC) trim(ghosts *LRU, over func() bool) {
+	for !ghosts.IsEmpty() && over() {
+		a.remove(ghosts.getVictim().key)
+	}
+}
+
+// the adaption step, at least 1
+func ratio(a int64, b int64) int64 {
+	if b == 0 {
+		return 1
+	}
+	return max(a/b, 1)
+}
+
+func (a *ARC) Get(key string) (Value, error) {
+	a.Lock()
+	defer a.Unlock()
+	if !a.resident(key) {
+		a.misses++
+		return nil, common.ErrKeyNotInCache
+	}
+	l := a.list[key]
+	node := l.key2node[key]
+	// lazy expiration
+	if node.expired(time.Now()) {
+		a.remove(key)
+		a.misses++
+		return nil, common.ErrKeyNotInCache
+	}
+	a.hits++
+	if l == a.t1 {
+		a.promotions++
+	}
+	a.remove(key)
+	a.t2.put(key, node.value, node.expire)
+	a.list[key] = a.t2
+	return node.value, nil
+}
+
+func (a *ARC) Peek(key string) (Value, error) {
+	a.Lock()
+	defer a.Unlock()
+	if !a.resident(key) {
+		return nil, common.ErrKeyNotInCache
+	}
+	return a.list[key].peek(key, time.Now())
+}
+
+func (a *ARC) Put(key string, value Value) error {
+	return a.PutWithTTL(key, value, 0)
+}
+
+func (a *ARC) PutWithTTL(key string, value Value, ttl time.Duration) error {
+	a.Lock()
+	defer a.Unlock()
+	size := entrySize(key, value)
+	if size > a.maxBytes {
+		return common.ErrCacheCapacityNotEnough
+	}
+	expire := expireAt(ttl)
+	switch l := a.list[key]; {
+	case l == a.t1 || l == a.t2:
+		// the update counts as an access
+		if l == a.t1 {
+			a.promotions++
+		}
+		a.remove(key)
+		a.replace(size, false)
+	case l == a.b1:
+		// t1 was too small to keep the key
+		a.p = min(a.p+ratio(a.b2.nbytes, a.b1.nbytes)*size, a.maxBytes)
+		a.remove(key)
+		a.replace(size, false)
+	case l == a.b2:
+		// t2 was too small to keep the key
+		a.p = max(a.p-ratio(a.b1.nbytes, a.b2.nbytes)*size, 0)
+		a.remove(key)
+		a.replace(size, true)
+	default:
+		a.trim(a.b1, func() bool {
+			return a.t1.nbytes+a.b1.nbytes+size > a.maxBytes
+		})
+		// t1 alone fills the cache, drop its lru entry without the ghost
+		for !a.t1.IsEmpty() && a.t1.nbytes+size > a.maxBytes {
+			a.remove(a.t1.getVictim().key)
+			a.evictions++
+		}
+		a.trim(a.b2, func() bool {
+			return a.t1.nbytes+a.t2.nbytes+a.b1.nbytes+a.b2.nbytes+size > 2*a.maxBytes
+		})
+		a.replace(size, false)
+		a.t1.put(key, value, expire)
+		a.list[key] = a.t1
+		return nil
+	}
+	a.t2.put(key, value, expire)
+	a.list[key] = a.t2
+	return nil
+}
+
+func (a *ARC) Delete(key string) error {
+	a.Lock()
+	defer a.Unlock()
+	if !a.resident(key) {
+		return common.ErrKeyNotInCache
+	}
+	a.remove(key)
+	return nil
+}
+
+func (a *ARC) Keys() []string {
+	a.Lock()
+	defer a.Unlock()
+	now := time.Now()
+	return append(a.t2.keys(now), a.t1.keys(now)...)
+}
+
+func (a *ARC) RemoveExpired() int {
+	a.Lock()
+	defer a.Unlock()
+	now := time.Now()
+	nodes := append(a.t1.expiredNodes(now), a.t2.expiredNodes(now)...)
+	for _, node := range nodes {
+		a.remove(node.key)
+	}
+	return len(nodes)
+}
+
+func (a *ARC) GetCurrentBytes() int64 {
+	return a.t1.nbytes + a.t2.nbytes
+}
+
+func (a *ARC) Stats() Stats {
+	a.Lock()
+	defer a.Unlock()
+	return a.stats(a.GetCurrentBytes(), a.maxBytes, a.t1.Len()+a.t2.Len())
+}
+
+// target bytes of t1
+func (a *ARC) Target() int64 {
+	return a.p
+}
+
+func (a *ARC) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "ARC(p: %d", a.p)
+	buf.WriteString(" t1: ")
+	buf.WriteString(a.t1.String())
+	buf.WriteString(" t2: ")
+	buf.WriteString(a.t2.String())
+	buf.WriteString(" b1: ")
+	buf.WriteString(a.b1.String())
+	buf.WriteString(" b2: ")
+	buf.WriteString(a.b2.String())
+	buf.WriteString(")")
+	return buf.String()
+}
+
+func (a *ARC) View() {
+	fmt.Println(a.String())
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestARCPutGet(t *testing.T) {
+	if _, err := NewARC(0); err == nil {
+		t.Fail()
+	}
+	arc, _ := NewARC(10)
+	if err := arc.Put("1", String("1234567890")); err == nil {
+		t.Fatal("the entry bigger than the cache must fail")
+	}
+	for i := 0; i < 5; i++ {
+		key, value := transformKeyAndValue(i, i)
+		arc.Put(key, value)
+	}
+	if arc.t1.nbytes != 10 || !arc.t2.IsEmpty() {
+		t.Fail()
+	}
+	// the entry accessed twice moves to t2
+	if v, err := arc.Get("0"); err != nil || v != String("0") || arc.list["0"] != arc.t2 {
+		t.Fail()
+	}
+	// the lru entry of t1 becomes a ghost
+	arc.Put("5", String("5"))
+	if _, err := arc.Get("1"); err == nil || arc.list["1"] != arc.b1 {
+		t.Fatal("the evicted key must be kept in b1")
+	}
+	if arc.GetCurrentBytes() != 10 {
+		t.Fail()
+	}
+	if err := arc.Delete("1"); err == nil {
+		t.Fatal("the ghost is not in the cache")
+	}
+	if err := arc.Delete("0"); err != nil {
+		t.Fail()
+	}
+	if len(arc.Keys()) != 4 {
+		t.Fail()
+	}
+}
+
+func TestARCAdapt(t *testing.T) {
+	arc, _ := NewARC(10)
+	for i := 0; i < 5; i++ {
+		key, value := transformKeyAndValue(i, i)
+		arc.Put(key, value)
+	}
+	// t1 is not full, so its lru entry becomes a ghost
+	arc.Get("4")
+	arc.Put("5", String("5"))
+	// the ghost hit in b1 grows the target of t1
+	arc.Put("0", String("0"))
+	if arc.Target() != 2 || arc.list["0"] != arc.t2 {
+		t.Fatalf("target %d after the b1 hit", arc.Target())
+	}
+	// t2 victim goes to b2 once t1 is under the target
+	for i := 6; i < 20; i++ {
+		key, value := transformKeyAndValue(i, i)
+		arc.Put(key, value)
+		arc.Get(key)
+	}
+	if arc.b2.IsEmpty() {
+		t.Fatal("the t2 victims must be kept in b2")
+	}
+	// the ghost hit in b2 shrinks the target of t1
+	target := arc.Target()
+	arc.Put(arc.b2.getVictim().key, String("x"))
+	if arc.Target() >= target {
+		t.Fatalf("target %d -> %d after the b2 hit", target, arc.Target())
+	}
+	if arc.GetCurrentBytes() > 10 || arc.b1.nbytes+arc.b2.nbytes+arc.GetCurrentBytes() > 20 {
+		t.Fail()
+	}
+}
+
+func TestARCScanResistant(t *testing.T) {
+	arc, _ := NewARC(200)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("h%02d", i)
+			if _, err := arc.Get(key); err != nil {
+				arc.Put(key, String("v"))
+			}
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		arc.Put(fmt.Sprintf("s%03d", i), String("v"))
+	}
+	// the scan only flows through t1
+	for i := 0; i < 10; i++ {
+		if _, err := arc.Get(fmt.Sprintf("h%02d", i)); err != nil {
+			t.Fatalf("the frequent key h%02d is pushed out by the scan", i)
+		}
+	}
+}
+
+func TestARCExpire(t *testing.T) {
+	arc, _ := NewARC(10)
+	arc.PutWithTTL("1", String("1"), 10*time.Millisecond)
+	arc.PutWithTTL("2", String("2"), 10*time.Millisecond)
+	arc.Put("3", String("3"))
+	time.Sleep(20 * time.Millisecond)
+	if _, err := arc.Get("1"); err == nil {
+		t.Fail()
+	}
+	if n := arc.RemoveExpired(); n != 1 {
+		t.Fail()
+	}
+	if keys := arc.Keys(); len(keys) != 1 || keys[0] != "3" {
+		t.Fail()
+	}
+}
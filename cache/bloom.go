@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"distributed_cache/common"
+	"math"
+	"sync"
+)
+
+// BloomFilter tells whether the key may have been added,
+// there are no false negatives, the false positive rate grows
+// once more keys than expected are added, the keys can't be removed
+type BloomFilter struct {
+	mu     sync.RWMutex
+	bits   []uint64
+	m      uint64 // bits
+	k      int    // hash functions
+	length int    // keys added, including the duplicates
+}
+
+// size the filter for the expected keys at the false positive rate in (0, 1)
+func NewBloomFilter(expected int, falsePositiveRate float64) (*BloomFilter, error) {
+	if expected <= 0 || falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, common.ErrPositiveParamNegative
+	}
+	// m = -n ln(p) / ln(2)^2, k = m/n ln(2)
+	m := uint64(math.Ceil(-float64(expected) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := max(int(math.Round(float64(m)/float64(expected)*math.Ln2)), 1)
+	return &BloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}, nil
+}
+
+// double hashing on the 64 bits fnv-1a of the key, like the count-min sketch
+func (b *BloomFilter) hashes(key string) (uint64, uint64) {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h, h>>32 | h<<32 | 1
+}
+
+func (b *BloomFilter) Add(key string) {
+	h1, h2 := b.hashes(key)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := 0; i < b.k; i++ {
+		idx := (h1 + uint64(i)*h2) % b.m
+		b.bits[idx/64] |= 1 << (idx % 64)
+	}
+	b.length++
+}
+
+// false means the key is never added
+func (b *BloomFilter) MayContain(key string) bool {
+	h1, h2 := b.hashes(key)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i := 0; i < b.k; i++ {
+		idx := (h1 + uint64(i)*h2) % b.m
+		if b.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *BloomFilter) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.length
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	if _, err := NewBloomFilter(100, 1); err == nil {
+		t.Fatal("the false positive rate must be less than 1")
+	}
+	b, err := NewBloomFilter(1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		b.Add(strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !b.MayContain(strconv.Itoa(i)) {
+			t.Fatalf("false negative of %d", i)
+		}
+	}
+	positives := 0
+	for i := 1000; i < 11000; i++ {
+		if b.MayContain(strconv.Itoa(i)) {
+			positives++
+		}
+	}
+	// 1% expected, allow some slack
+	if rate := float64(positives) / 10000; rate > 0.02 {
+		t.Fatalf("false positive rate %f", rate)
+	}
+	if b.Len() != 1000 {
+		t.Fatal(b.Len())
+	}
+}
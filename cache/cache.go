@@ -5,11 +5,24 @@ import (
 	"distributed_cache/common"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Cache interface {
 	Get(key string) (Value, error)
+	// Peek is Get without the side effects: the recency, the access
+	// counts and the hit/miss counters are left as they are
+	Peek(key string) (Value, error)
 	Put(key string, value Value) error
+	// ttl <= 0 means the entry never expires
+	PutWithTTL(key string, value Value, ttl time.Duration) error
+	Delete(key string) error
+	// keys of the entries which are not expired
+	Keys() []string
+	// remove all the expired entries, return the removed count
+	RemoveExpired() int
+	Stats() Stats
 	View()
 }
 
@@ -18,6 +31,9 @@ type LRU struct {
 	maxBytes   int64                  // lru max size
 	key2node   map[string]*linkedNode // hash map
 	linkedList *linkedList            // double linkedList
+	shared     *atomic.Int64          // optional, the bytes of the budget shared with other caches
+	counters
+	notifier
 	sync.Mutex
 }
 
@@ -29,27 +45,90 @@ func (lru *LRU) remove(key string) {
 	node := lru.key2node[key]
 	lru.linkedList.remove(node)
 	delete(lru.key2node, key)
-	lru.nbytes -= entrySize(key, node.value)
+	lru.addBytes(-entrySize(key, node.value))
 }
 
-func (lru *LRU) Get(key string) (Value, error) {
+func (lru *LRU) addBytes(n int64) {
+	lru.nbytes += n
+	if lru.shared != nil {
+		lru.shared.Add(n)
+	}
+}
+
+// remove the entry and report it to the listener
+func (lru *LRU) drop(key string, reason RemovalReason) {
+	lru.record(key, lru.key2node[key].value, reason)
+	lru.remove(key)
+}
+
+// release the lock, then report the removals
+func (lru *LRU) unlock() {
+	removals, listener := lru.take()
+	lru.Unlock()
+	notify(removals, listener)
+}
+
+func (lru *LRU) SetRemovalListener(listener RemovalListener) {
 	lru.Lock()
 	defer lru.Unlock()
+	lru.listener = listener
+}
+
+func (lru *LRU) Get(key string) (Value, error) {
+	lru.Lock()
+	defer lru.unlock()
 	node, ok := lru.key2node[key]
 	if !ok {
 		// msg := fmt.Sprintf("key [%s] not found in lru cache", key)
 		// errors.New(msg)
+		lru.misses++
 		return nil, common.ErrKeyNotInCache
 	}
+	// lazy expiration
+	if node.expired(time.Now()) {
+		lru.drop(key, RemovedByExpired)
+		lru.misses++
+		return nil, common.ErrKeyNotInCache
+	}
+	lru.hits++
 	// move the node to head
 	lru.linkedList.moveToHead(node)
 	val := node.value
 	return val, nil
 }
 
-func (lru *LRU) Put(key string, value Value) (err error) {
+func (lru *LRU) Peek(key string) (Value, error) {
 	lru.Lock()
 	defer lru.Unlock()
+	return lru.peek(key, time.Now())
+}
+
+func (lru *LRU) peek(key string, now time.Time) (Value, error) {
+	node, ok := lru.key2node[key]
+	if !ok || node.expired(now) {
+		return nil, common.ErrKeyNotInCache
+	}
+	return node.value, nil
+}
+
+// get the node and move it to head, without expiration check
+func (lru *LRU) get(key string) *linkedNode {
+	node := lru.key2node[key]
+	lru.linkedList.moveToHead(node)
+	return node
+}
+
+func (lru *LRU) Put(key string, value Value) error {
+	return lru.PutWithTTL(key, value, 0)
+}
+
+func (lru *LRU) PutWithTTL(key string, value Value, ttl time.Duration) error {
+	lru.Lock()
+	defer lru.unlock()
+	return lru.put(key, value, expireAt(ttl))
+}
+
+func (lru *LRU) put(key string, value Value, expire time.Time) (err error) {
 	if entrySize(key, value) > lru.maxBytes {
 		// msg := "the entry size is bigger than the cache max bytes"
 		// err = errors.New(msg)
@@ -61,28 +140,105 @@ func (lru *LRU) Put(key string, value Value) (err error) {
 		nbytes := entrySize(key, value) - entrySize(key, node.value)
 		for lru.nbytes+nbytes > lru.maxBytes {
 			victim := lru.getVictim()
-			lru.remove(victim.key)
+			lru.drop(victim.key, RemovedByCapacity)
+			lru.evictions++
 		}
-		lru.nbytes += nbytes
+		lru.addBytes(nbytes)
+		lru.record(key, node.value, RemovedByReplace)
 		node.setValue(value)
+		node.expire = expire
 		lru.linkedList.moveToHead(node)
 		return
 	}
 	nbytes := entrySize(key, value)
 	for lru.nbytes+nbytes > lru.maxBytes {
 		victim := lru.getVictim()
-		lru.remove(victim.key)
+		lru.drop(victim.key, RemovedByCapacity)
+		lru.evictions++
 	}
 	node := lru.linkedList.insert(key, value)
+	node.expire = expire
 	lru.key2node[key] = node
-	lru.nbytes += nbytes
+	lru.addBytes(nbytes)
 	return
 }
 
+func (lru *LRU) shareBudget(used *atomic.Int64) {
+	lru.Lock()
+	defer lru.Unlock()
+	lru.shared = used
+}
+
+func (lru *LRU) evictOldest(keep string) bool {
+	lru.Lock()
+	defer lru.unlock()
+	if lru.IsEmpty() || lru.getVictim().key == keep {
+		return false
+	}
+	lru.drop(lru.getVictim().key, RemovedByCapacity)
+	lru.evictions++
+	return true
+}
+
+func (lru *LRU) Delete(key string) error {
+	lru.Lock()
+	defer lru.unlock()
+	if _, ok := lru.key2node[key]; !ok {
+		return common.ErrKeyNotInCache
+	}
+	lru.drop(key, RemovedByDelete)
+	return nil
+}
+
+func (lru *LRU) Keys() []string {
+	lru.Lock()
+	defer lru.Unlock()
+	return lru.keys(time.Now())
+}
+
+func (lru *LRU) keys(now time.Time) []string {
+	nodes := lru.linkedList.filter(func(n *linkedNode) bool {
+		return !n.expired(now)
+	})
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = node.key
+	}
+	return keys
+}
+
+func (lru *LRU) expiredNodes(now time.Time) []*linkedNode {
+	return lru.linkedList.filter(func(n *linkedNode) bool {
+		return n.expired(now)
+	})
+}
+
+func (lru *LRU) RemoveExpired() int {
+	lru.Lock()
+	defer lru.unlock()
+	nodes := lru.expiredNodes(time.Now())
+	for _, node := range nodes {
+		lru.drop(node.key, RemovedByExpired)
+	}
+	return len(nodes)
+}
+
 func (lru *LRU) GetCurrentBytes() int64 {
 	return lru.nbytes
 }
 
+func (lru *LRU) Stats() Stats {
+	lru.Lock()
+	defer lru.Unlock()
+	return lru.stats(lru.nbytes, lru.maxBytes, len(lru.key2node))
+}
+
+func (lru *LRU) Len() int {
+	lru.Lock()
+	defer lru.Unlock()
+	return len(lru.key2node)
+}
+
 func (lru *LRU) IsEmpty() bool {
 	return len(lru.key2node) == 0
 }
@@ -115,13 +271,44 @@ type LRUK struct {
 	nbytes         int64
 	maxBytes       int64
 	k              int
-	historyCounter map[string]int // record the count of the node access
+	historyCounter map[string]int       // record the count of the node access
+	lastAccess     map[string]time.Time // the last counted access of the node
+	history        *history             // records of the evicted keys, nil means disabled
+	crp            time.Duration        // correlated reference period
 	lru1           *LRU
 	lru2           *LRU
+	counters
+	notifier
 	sync.RWMutex
 }
 
-func NewLRUK(maxBytes int64, k int) (*LRUK, error) {
+// the records of at most DefaultHistorySize evicted keys are kept by default
+var DefaultHistorySize = 1024
+
+type LRUKOption func(l *LRUK)
+
+// the access counts of at most n evicted keys are kept,
+// so the evicted key accessed often goes straight to lru2 once it is back,
+// n <= 0 disables the history
+func WithHistorySize(n int) LRUKOption {
+	return func(l *LRUK) {
+		if n <= 0 {
+			l.history = nil
+			return
+		}
+		l.history = newHistory(n)
+	}
+}
+
+// the accesses within d after the last counted access are correlated,
+// they are not counted, so a burst doesn't count as k accesses
+func WithCorrelatedPeriod(d time.Duration) LRUKOption {
+	return func(l *LRUK) {
+		l.crp = d
+	}
+}
+
+func NewLRUK(maxBytes int64, k int, opts ...LRUKOption) (*LRUK, error) {
 	if maxBytes <= 0 || k <= 0 {
 		// msg := fmt.Sprintf(
 		// 	`cache maxBytes, threshold-k must be positive, but you give the maxBytes[%d] k[%d]`,
@@ -136,8 +323,13 @@ func NewLRUK(maxBytes int64, k int) (*LRUK, error) {
 	cache.maxBytes = maxBytes
 	cache.k = k + 1
 	cache.historyCounter = make(map[string]int)
+	cache.lastAccess = make(map[string]time.Time)
+	cache.history = newHistory(DefaultHistorySize)
 	cache.lru1, _ = NewLRU(maxBytes)
 	cache.lru2, _ = NewLRU(maxBytes)
+	for _, opt := range opts {
+		opt(&cache)
+	}
 	return &cache, nil
 }
 
@@ -151,50 +343,100 @@ func (l *LRUK) getVictim() *linkedNode {
 	return victim
 }
 
-func (l *LRUK) remove(key string, value Value) {
+func (l *LRUK) remove(key string, value Value, reason RemovalReason) {
+	l.record(key, value, reason)
 	if l.historyCounter[key] < l.k {
 		l.lru1.remove(key)
 	} else {
 		l.lru2.remove(key)
 	}
+	if l.history != nil {
+		l.history.add(key, record{count: l.historyCounter[key], last: l.lastAccess[key]})
+	}
 	delete(l.historyCounter, key)
+	delete(l.lastAccess, key)
 	l.nbytes -= entrySize(key, value)
 }
 
-func (l *LRUK) switchTo(key string, value Value) {
+// release the lock, then report the removals
+func (l *LRUK) unlock() {
+	removals, listener := l.take()
+	l.Unlock()
+	notify(removals, listener)
+}
+
+func (l *LRUK) SetRemovalListener(listener RemovalListener) {
+	l.Lock()
+	defer l.Unlock()
+	l.listener = listener
+}
+
+func (l *LRUK) switchTo(key string) {
+	node := l.lru1.key2node[key]
 	l.lru1.remove(key)
-	l.lru2.Put(key, value)
+	l.lru2.put(key, node.value, node.expire)
+	l.promotions++
 }
 
-func (l *LRUK) incrementCount(key string, value Value) {
+func (l *LRUK) incrementCount(key string) {
+	now := time.Now()
+	if last, ok := l.lastAccess[key]; ok && now.Sub(last) < l.crp {
+		return
+	}
+	l.lastAccess[key] = now
 	l.historyCounter[key]++
 	if l.historyCounter[key] == l.k {
-		l.switchTo(key, value)
+		l.switchTo(key)
+	}
+}
+
+// the lru which the key belongs to
+func (l *LRUK) lruOf(key string) *LRU {
+	if l.historyCounter[key] < l.k {
+		return l.lru1
 	}
+	return l.lru2
 }
 
 func (l *LRUK) Get(key string) (Value, error) {
 	l.Lock()
-	defer l.Unlock()
-	count, ok := l.historyCounter[key]
-	if !ok {
+	defer l.unlock()
+	if _, ok := l.historyCounter[key]; !ok {
 		// msg := fmt.Sprintf("the key[%s] not in the cache", key)
 		// errors.New(msg)
+		l.misses++
 		return nil, common.ErrKeyNotInCache
 	}
-	var value Value
-	if count < l.k {
-		value, _ = l.lru1.Get(key)
-	} else {
-		value, _ = l.lru2.Get(key)
+	node := l.lruOf(key).get(key)
+	// lazy expiration
+	if node.expired(time.Now()) {
+		l.remove(key, node.value, RemovedByExpired)
+		l.misses++
+		return nil, common.ErrKeyNotInCache
 	}
-	l.incrementCount(key, value)
+	l.hits++
+	value := node.value
+	l.incrementCount(key)
 	return value, nil
 }
 
-func (l *LRUK) Put(key string, value Value) (err error) {
+func (l *LRUK) Peek(key string) (Value, error) {
 	l.Lock()
 	defer l.Unlock()
+	if _, ok := l.historyCounter[key]; !ok {
+		return nil, common.ErrKeyNotInCache
+	}
+	return l.lruOf(key).peek(key, time.Now())
+}
+
+func (l *LRUK) Put(key string, value Value) error {
+	return l.PutWithTTL(key, value, 0)
+}
+
+func (l *LRUK) PutWithTTL(key string, value Value, ttl time.Duration) (err error) {
+	l.Lock()
+	defer l.unlock()
+	expire := expireAt(ttl)
 	if entrySize(key, value) > l.maxBytes {
 		// err = errors.New("the entry size is bigger than the cache max bytes")
 		err = common.ErrCacheCapacityNotEnough
@@ -204,41 +446,112 @@ func (l *LRUK) Put(key string, value Value) (err error) {
 		var nodeValue Value
 		var flag = false
 		if count < l.k {
-			nodeValue, _ = l.lru1.Get(key)
+			nodeValue = l.lru1.get(key).value
 		} else {
-			nodeValue, _ = l.lru2.Get(key)
+			nodeValue = l.lru2.get(key).value
 			flag = true
 		}
 		nbytes := entrySize(key, value) - entrySize(key, nodeValue)
 		for l.nbytes+nbytes > l.maxBytes {
 			victim := l.getVictim()
-			l.remove(victim.key, victim.value)
+			l.remove(victim.key, victim.value, RemovedByCapacity)
+			l.evictions++
 		}
+		l.record(key, nodeValue, RemovedByReplace)
 		if !flag {
-			l.lru1.Put(key, value)
+			l.lru1.put(key, value, expire)
 		} else {
-			l.lru2.Put(key, value)
+			l.lru2.put(key, value, expire)
 		}
-		l.incrementCount(key, value)
+		l.incrementCount(key)
 		l.nbytes += nbytes
 		return
 	}
 
+	// the evicted key keeps its access record
+	var rec record
+	var readmitted bool
+	if l.history != nil {
+		rec, readmitted = l.history.take(key)
+	}
 	nbytes := entrySize(key, value)
 	for l.nbytes+nbytes > l.maxBytes {
 		victim := l.getVictim()
-		l.remove(victim.key, victim.value)
+		l.remove(victim.key, victim.value, RemovedByCapacity)
+		l.evictions++
+	}
+	if readmitted {
+		l.historyCounter[key] = rec.count
+		l.lastAccess[key] = rec.last
 	}
-	l.lru1.Put(key, value)
-	l.incrementCount(key, value)
+	l.lruOf(key).put(key, value, expire)
+	l.incrementCount(key)
 	l.nbytes += nbytes
 	return
 }
 
+// the bytes are counted by lru1 and lru2
+func (l *LRUK) shareBudget(used *atomic.Int64) {
+	l.Lock()
+	defer l.Unlock()
+	l.lru1.shared = used
+	l.lru2.shared = used
+}
+
+func (l *LRUK) evictOldest(keep string) bool {
+	l.Lock()
+	defer l.unlock()
+	if len(l.historyCounter) == 0 {
+		return false
+	}
+	victim := l.getVictim()
+	if victim.key == keep {
+		return false
+	}
+	l.remove(victim.key, victim.value, RemovedByCapacity)
+	l.evictions++
+	return true
+}
+
+func (l *LRUK) Delete(key string) error {
+	l.Lock()
+	defer l.unlock()
+	if _, ok := l.historyCounter[key]; !ok {
+		return common.ErrKeyNotInCache
+	}
+	node := l.lruOf(key).key2node[key]
+	l.remove(key, node.value, RemovedByDelete)
+	return nil
+}
+
+func (l *LRUK) Keys() []string {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	return append(l.lru2.keys(now), l.lru1.keys(now)...)
+}
+
+func (l *LRUK) RemoveExpired() int {
+	l.Lock()
+	defer l.unlock()
+	now := time.Now()
+	nodes := append(l.lru1.expiredNodes(now), l.lru2.expiredNodes(now)...)
+	for _, node := range nodes {
+		l.remove(node.key, node.value, RemovedByExpired)
+	}
+	return len(nodes)
+}
+
 func (l *LRUK) GetCurrentBytes() int64 {
 	return l.nbytes
 }
 
+func (l *LRUK) Stats() Stats {
+	l.Lock()
+	defer l.Unlock()
+	return l.stats(l.nbytes, l.maxBytes, len(l.historyCounter))
+}
+
 func (l *LRUK) Getl1CurrentBytes() int64 {
 	return l.lru1.GetCurrentBytes()
 }
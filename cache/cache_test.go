@@ -3,8 +3,10 @@ package cache
 import (
 	"fmt"
 	"math/rand"
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 )
 
 func transformKey(i int) string {
@@ -307,6 +309,204 @@ func TestLrukGetVictim(t *testing.T) {
 	fmt.Println(lruk)
 }
 
+func TestLruDelete(t *testing.T) {
+	lru, _ := NewLRU(10)
+	lru.Put("1", String("1"))
+	lru.Put("2", String("2"))
+	if err := lru.Delete("1"); err != nil {
+		t.Fail()
+	}
+	if err := lru.Delete("3"); err == nil {
+		t.Fail()
+	}
+	if _, err := lru.Get("1"); err == nil || lru.GetCurrentBytes() != 2 {
+		t.Fail()
+	}
+}
+
+func TestLrukDelete(t *testing.T) {
+	lruk, _ := NewLRUK(10, 2)
+	lruk.Put("1", String("1"))
+	lruk.Get("1")
+	lruk.Get("1")
+	lruk.Put("2", String("2"))
+	checklrukSize(lruk, 2, 2, 2, t)
+	if err := lruk.Delete("1"); err != nil {
+		t.Fail()
+	}
+	checklrukSize(lruk, 2, 0, 1, t)
+	if err := lruk.Delete("2"); err != nil {
+		t.Fail()
+	}
+	checklrukSize(lruk, 0, 0, 0, t)
+	if err := lruk.Delete("2"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestLrukKeys(t *testing.T) {
+	lruk, _ := NewLRUK(10, 2)
+	lruk.Put("1", String("1"))
+	lruk.Get("1")
+	lruk.Get("1")
+	lruk.Put("2", String("2"))
+	lruk.PutWithTTL("3", String("3"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if keys := lruk.Keys(); !reflect.DeepEqual(keys, []string{"1", "2"}) {
+		fmt.Printf("get keys %v\n", keys)
+		t.Fail()
+	}
+}
+
+func TestLruExpire(t *testing.T) {
+	lru, _ := NewLRU(10)
+	lru.PutWithTTL("1", String("1"), 10*time.Millisecond)
+	lru.Put("2", String("2"))
+	if _, err := lru.Get("1"); err != nil {
+		t.Fail()
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := lru.Get("1"); err == nil {
+		fmt.Println("the expired key must not be hit")
+		t.Fail()
+	}
+	if _, err := lru.Get("2"); err != nil || lru.GetCurrentBytes() != 2 {
+		t.Fail()
+	}
+}
+
+func TestLruRemoveExpired(t *testing.T) {
+	lru, _ := NewLRU(10)
+	for i := 0; i < 4; i++ {
+		key, value := transformKeyAndValue(i, i)
+		lru.PutWithTTL(key, value, 10*time.Millisecond)
+	}
+	lru.Put("4", String("4"))
+	time.Sleep(20 * time.Millisecond)
+	if n := lru.RemoveExpired(); n != 4 {
+		fmt.Printf("must remove 4 expired entries, but remove %d\n", n)
+		t.Fail()
+	}
+	if lru.GetCurrentBytes() != 2 || len(lru.key2node) != 1 {
+		t.Fail()
+	}
+}
+
+func TestLrukExpire(t *testing.T) {
+	lruk, _ := NewLRUK(10, 2)
+	lruk.PutWithTTL("1", String("1"), 10*time.Millisecond)
+	lruk.Get("1")
+	lruk.Get("1")
+	lruk.PutWithTTL("2", String("2"), 10*time.Millisecond)
+	checklrukSize(lruk, 2, 2, 2, t)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := lruk.Get("1"); err == nil {
+		t.Fail()
+	}
+	checklrukSize(lruk, 2, 0, 1, t)
+	if n := lruk.RemoveExpired(); n != 1 {
+		t.Fail()
+	}
+	checklrukSize(lruk, 0, 0, 0, t)
+}
+
+func TestLrukHistory(t *testing.T) {
+	lruk, _ := NewLRUK(2, 2)
+	// "1" is accessed 3 times and goes to lru2
+	lruk.Put("1", String("1"))
+	lruk.Get("1")
+	lruk.Get("1")
+	checklrukSize(lruk, 0, 2, 1, t)
+	// evicted by the new key
+	lruk.Put("2", String("2"))
+	checklrukSize(lruk, 2, 0, 1, t)
+	if lruk.history.len() != 1 {
+		t.Fatal("the evicted key must be kept in the history")
+	}
+	// the re-admitted key goes straight to lru2
+	lruk.Put("1", String("1"))
+	checklrukSize(lruk, 0, 2, 1, t)
+	if lruk.historyCounter["1"] != 4 || lruk.history.len() != 1 {
+		t.Fail()
+	}
+
+	// the history is bounded
+	lruk, _ = NewLRUK(2, 2, WithHistorySize(2))
+	for i := 0; i < 5; i++ {
+		key, value := transformKeyAndValue(i, i)
+		lruk.Put(key, value)
+	}
+	if lruk.history.len() != 2 {
+		t.Fail()
+	}
+	if _, ok := lruk.history.take("3"); !ok {
+		t.Fatal("the latest evicted key must be kept")
+	}
+
+	// disabled history forgets the evicted key
+	lruk, _ = NewLRUK(2, 1, WithHistorySize(0))
+	lruk.Put("1", String("1"))
+	lruk.Get("1")
+	lruk.Put("2", String("2"))
+	lruk.Put("1", String("1"))
+	checklrukSize(lruk, 2, 0, 1, t)
+}
+
+func TestLrukCorrelatedPeriod(t *testing.T) {
+	lruk, _ := NewLRUK(10, 2, WithCorrelatedPeriod(20*time.Millisecond))
+	lruk.Put("1", String("1"))
+	// the burst counts once
+	for i := 0; i < 5; i++ {
+		lruk.Get("1")
+	}
+	if lruk.historyCounter["1"] != 1 {
+		t.Fatalf("count %d after the burst", lruk.historyCounter["1"])
+	}
+	checklrukSize(lruk, 2, 0, 1, t)
+	time.Sleep(25 * time.Millisecond)
+	lruk.Get("1")
+	time.Sleep(25 * time.Millisecond)
+	lruk.Get("1")
+	checklrukSize(lruk, 0, 2, 1, t)
+}
+
+func TestJanitor(t *testing.T) {
+	lruk, _ := NewLRUK(10, 2)
+	janitor, err := StartJanitor(lruk, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer janitor.Stop()
+	lruk.PutWithTTL("1", String("1"), 10*time.Millisecond)
+	lruk.Put("2", String("2"))
+	time.Sleep(50 * time.Millisecond)
+	lruk.Lock()
+	defer lruk.Unlock()
+	if lruk.GetCurrentBytes() != 2 {
+		fmt.Printf("the janitor must reclaim the expired bytes, current bytes %d\n", lruk.GetCurrentBytes())
+		t.Fail()
+	}
+}
+
+func TestLruLenWithJanitor(t *testing.T) {
+	lru, _ := NewLRU(100)
+	janitor, err := StartJanitor(lru, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer janitor.Stop()
+	for i := 0; i < 50; i++ {
+		key, value := transformKeyAndValue(i, i)
+		lru.PutWithTTL(key, value, time.Millisecond)
+		lru.Len()
+	}
+	time.Sleep(20 * time.Millisecond)
+	if lru.Len() != 0 {
+		fmt.Printf("the janitor must remove the expired entries, len %d\n", lru.Len())
+		t.Fail()
+	}
+}
+
 func BenchmarkLrukConcurrent(b *testing.B) {
 	var (
 		size int64 = 100
@@ -0,0 +1,62 @@
+package cache
+
+import "time"
+
+// the access record of the evicted key
+type record struct {
+	count int
+	last  time.Time // the last counted access
+}
+
+func (r record) NBytes() int {
+	return 0
+}
+
+func (r record) Bytes() []byte {
+	return nil
+}
+
+// history remembers the access records of the recently evicted keys,
+// the oldest record is dropped once the history is full
+type history struct {
+	size       int
+	key2node   map[string]*linkedNode
+	linkedList *linkedList
+}
+
+func newHistory(size int) *history {
+	return &history{
+		size:       size,
+		key2node:   make(map[string]*linkedNode),
+		linkedList: newLinkedList(),
+	}
+}
+
+func (h *history) add(key string, r record) {
+	if node, ok := h.key2node[key]; ok {
+		node.setValue(r)
+		h.linkedList.moveToHead(node)
+		return
+	}
+	if len(h.key2node) >= h.size {
+		oldest := h.linkedList.head.prev
+		h.linkedList.remove(oldest)
+		delete(h.key2node, oldest.key)
+	}
+	h.key2node[key] = h.linkedList.insert(key, r)
+}
+
+// take the record out of the history
+func (h *history) take(key string) (record, bool) {
+	node, ok := h.key2node[key]
+	if !ok {
+		return record{}, false
+	}
+	h.linkedList.remove(node)
+	delete(h.key2node, key)
+	return node.value.(record), true
+}
+
+func (h *history) len() int {
+	return len(h.key2node)
+}
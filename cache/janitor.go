@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"distributed_cache/common"
+	"sync"
+	"time"
+)
+
+// Janitor periodically removes the expired entries of the cache,
+// so the bytes of the entries that are never read again can be reclaimed
+type Janitor struct {
+	cache    Cache
+	interval time.Duration
+	stop     chan struct{}
+	once     sync.Once
+}
+
+func StartJanitor(cache Cache, interval time.Duration) (*Janitor, error) {
+	if interval <= 0 {
+		return nil, common.ErrPositiveParamNegative
+	}
+	j := &Janitor{
+		cache:    cache,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	go j.run()
+	return j, nil
+}
+
+func (j *Janitor) run() {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.cache.RemoveExpired()
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+func (j *Janitor) Stop() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+}
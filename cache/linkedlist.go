@@ -3,11 +3,25 @@ package cache
 import (
 	"bytes"
 	"fmt"
+	"time"
 )
 
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // zero means the entry never expires
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// convert ttl to the absolute expire time, ttl <= 0 means never expire
+func expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
 }
 
 func entrySize(key string, value Value) int64 {
@@ -89,6 +103,17 @@ func (l *linkedList) moveToHead(n *linkedNode) {
 	l.addToHead(n)
 }
 
+// collect the nodes which satisfy the predicate, from head to tail
+func (l *linkedList) filter(pred func(n *linkedNode) bool) []*linkedNode {
+	var nodes []*linkedNode
+	for p := l.head.next; p != l.head; p = p.next {
+		if pred(p) {
+			nodes = append(nodes, p)
+		}
+	}
+	return nodes
+}
+
 func (l *linkedList) insert(key string, value Value) *linkedNode {
 	new := newLinkedNode(key, value)
 	l.addToHead(new)
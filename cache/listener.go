@@ -0,0 +1,67 @@
+package cache
+
+// RemovalReason tells why the entry left the cache
+type RemovalReason int
+
+const (
+	RemovedByCapacity RemovalReason = iota // evicted to make room for the other entries
+	RemovedByExpired                       // the ttl elapsed
+	RemovedByDelete                        // removed by Delete
+	RemovedByReplace                       // the value is overwritten by Put
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case RemovedByCapacity:
+		return "capacity"
+	case RemovedByExpired:
+		return "expired"
+	case RemovedByDelete:
+		return "delete"
+	case RemovedByReplace:
+		return "replace"
+	}
+	return "unknown"
+}
+
+// RemovalListener is called after the cache lock is released,
+// so it may call the cache again
+type RemovalListener func(key string, value Value, reason RemovalReason)
+
+// Notifier is implemented by the caches which report the removed entries
+type Notifier interface {
+	// must be set before the cache is used
+	SetRemovalListener(listener RemovalListener)
+}
+
+type removal struct {
+	key    string
+	value  Value
+	reason RemovalReason
+}
+
+// notifier collects the removals under the cache lock,
+// they are reported once the lock is released
+type notifier struct {
+	listener RemovalListener
+	pending  []removal
+}
+
+func (n *notifier) record(key string, value Value, reason RemovalReason) {
+	if n.listener != nil {
+		n.pending = append(n.pending, removal{key: key, value: value, reason: reason})
+	}
+}
+
+// take the pending removals with the listener, called under the cache lock
+func (n *notifier) take() ([]removal, RemovalListener) {
+	removals := n.pending
+	n.pending = nil
+	return removals, n.listener
+}
+
+func notify(removals []removal, listener RemovalListener) {
+	for _, r := range removals {
+		listener(r.key, r.value, r.reason)
+	}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+type removed struct {
+	key    string
+	value  Value
+	reason RemovalReason
+}
+
+func recordRemovals(n Notifier) *[]removed {
+	var removals []removed
+	n.SetRemovalListener(func(key string, value Value, reason RemovalReason) {
+		removals = append(removals, removed{key, value, reason})
+	})
+	return &removals
+}
+
+func checkRemovals(t *testing.T, got []removed, expected ...removed) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("removals %v, expected %v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("removals %v, expected %v", got, expected)
+		}
+	}
+}
+
+func TestLRURemovalListener(t *testing.T) {
+	lru, _ := NewLRU(4)
+	removals := recordRemovals(lru)
+	lru.Put("1", String("1"))
+	lru.Put("1", String("a"))
+	lru.Put("2", String("2"))
+	lru.Put("3", String("3"))
+	lru.Delete("2")
+	lru.PutWithTTL("4", String("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	lru.Get("4")
+	checkRemovals(t, *removals,
+		removed{"1", String("1"), RemovedByReplace},
+		removed{"1", String("a"), RemovedByCapacity},
+		removed{"2", String("2"), RemovedByDelete},
+		removed{"4", String("4"), RemovedByExpired},
+	)
+}
+
+func TestLRUKRemovalListener(t *testing.T) {
+	lruk, _ := NewLRUK(4, 2)
+	removals := recordRemovals(lruk)
+	lruk.Put("1", String("1"))
+	lruk.Put("1", String("a"))
+	lruk.Put("2", String("2"))
+	lruk.Put("3", String("3"))
+	lruk.Delete("2")
+	lruk.PutWithTTL("4", String("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	lruk.RemoveExpired()
+	checkRemovals(t, *removals,
+		removed{"1", String("1"), RemovedByReplace},
+		removed{"1", String("a"), RemovedByCapacity},
+		removed{"2", String("2"), RemovedByDelete},
+		removed{"4", String("4"), RemovedByExpired},
+	)
+}
+
+func TestRemovalListenerReentrant(t *testing.T) {
+	sharded, _ := NewShardedLRUK(2, 100, 2)
+	// the listener is called without the lock, so it can write the cache again
+	sharded.SetRemovalListener(func(key string, value Value, reason RemovalReason) {
+		if reason == RemovedByDelete {
+			sharded.Put(key+"-deleted", value)
+		}
+	})
+	sharded.Put("1", String("1"))
+	sharded.Delete("1")
+	if v, err := sharded.Get("1-deleted"); err != nil || v != String("1") {
+		t.Fail()
+	}
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"distributed_cache/common"
+	"sort"
+	"sync"
+)
+
+// Policy creates the cache of the eviction policy,
+// k is the threshold of LRU-k, the other policies ignore it
+type Policy func(maxBytes int64, k int) (Cache, error)
+
+var (
+	policiesMu sync.RWMutex
+	policies   = make(map[string]Policy)
+)
+
+func init() {
+	RegisterPolicy("lru", func(maxBytes int64, k int) (Cache, error) {
+		return NewLRU(maxBytes)
+	})
+	RegisterPolicy("lruk", func(maxBytes int64, k int) (Cache, error) {
+		return NewLRUK(maxBytes, k)
+	})
+	RegisterPolicy("tinylfu", func(maxBytes int64, k int) (Cache, error) {
+		return NewTinyLFU(maxBytes)
+	})
+	RegisterPolicy("arc", func(maxBytes int64, k int) (Cache, error) {
+		return NewARC(maxBytes)
+	})
+}
+
+// RegisterPolicy makes the policy available by the name,
+// it panics if the name is registered twice
+func RegisterPolicy(name string, policy Policy) {
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+	if policy == nil {
+		panic("cache: RegisterPolicy policy is nil")
+	}
+	if _, ok := policies[name]; ok {
+		panic("cache: RegisterPolicy called twice for policy " + name)
+	}
+	policies[name] = policy
+}
+
+// NewPolicy creates the cache of the registered policy
+func NewPolicy(name string, maxBytes int64, k int) (Cache, error) {
+	policiesMu.RLock()
+	policy, ok := policies[name]
+	policiesMu.RUnlock()
+	if !ok {
+		return nil, common.ErrPolicyNotRegistered
+	}
+	return policy(maxBytes, k)
+}
+
+// names of the registered policies, sorted
+func Policies() []string {
+	policiesMu.RLock()
+	defer policiesMu.RUnlock()
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
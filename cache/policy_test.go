@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"distributed_cache/common"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	names := Policies()
+	if len(names) != 4 || names[0] != "arc" || names[3] != "tinylfu" {
+		t.Fatalf("policies %v", names)
+	}
+	c, err := NewPolicy("lruk", 10, 2)
+	if lruk, ok := c.(*LRUK); err != nil || !ok || lruk.GetK() != 2 {
+		t.Fail()
+	}
+	if _, err := NewPolicy("lru", 0, 2); err != common.ErrPositiveParamNegative {
+		t.Fail()
+	}
+	if _, err := NewPolicy("unknown", 10, 2); err != common.ErrPolicyNotRegistered {
+		t.Fail()
+	}
+	RegisterPolicy("sharded-lru", func(maxBytes int64, k int) (Cache, error) {
+		return NewShardedLRU(4, maxBytes)
+	})
+	if c, err := NewPolicy("sharded-lru", 100, 2); err != nil || c.(*Sharded).ShardCount() != 4 {
+		t.Fail()
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("the policy registered twice must panic")
+		}
+	}()
+	RegisterPolicy("lru", func(maxBytes int64, k int) (Cache, error) {
+		return NewLRU(maxBytes)
+	})
+}
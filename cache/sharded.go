@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"bytes"
+	"distributed_cache/common"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// NewShard creates a shard, maxBytes is the budget shared by all the shards
+type NewShard func(maxBytes int64) (Cache, error)
+
+// the shard which counts its bytes in the shared budget, LRU and LRUK implement it
+type budgeted interface {
+	Cache
+	shareBudget(used *atomic.Int64)
+	// evict the lru entry unless it is the keep one, false if nothing is evicted
+	evictOldest(keep string) bool
+}
+
+// Sharded spreads the keys across the independent shards by the key hash,
+// every shard has its own lock, so the operations on different shards run in parallel,
+// the shards share one byte budget, so a hot shard may take most of it
+type Sharded struct {
+	shards   []budgeted
+	maxBytes int64
+	used     atomic.Int64 // the bytes of all the shards
+}
+
+// every shard is created with the whole maxBytes, so an entry fits if it fits in the budget
+func NewSharded(n int, maxBytes int64, newShard NewShard) (*Sharded, error) {
+	if n <= 0 || maxBytes <= 0 {
+		return &Sharded{}, common.ErrPositiveParamNegative
+	}
+	s := &Sharded{shards: make([]budgeted, n), maxBytes: maxBytes}
+	for i := range s.shards {
+		shard, err := newShard(maxBytes)
+		if err != nil {
+			return &Sharded{}, err
+		}
+		b, ok := shard.(budgeted)
+		if !ok {
+			return &Sharded{}, common.ErrBudgetNotSupported
+		}
+		b.shareBudget(&s.used)
+		s.shards[i] = b
+	}
+	return s, nil
+}
+
+func NewShardedLRU(n int, maxBytes int64) (*Sharded, error) {
+	return NewSharded(n, maxBytes, func(maxBytes int64) (Cache, error) {
+		return NewLRU(maxBytes)
+	})
+}
+
+func NewShardedLRUK(n int, maxBytes int64, k int, opts ...LRUKOption) (*Sharded, error) {
+	return NewSharded(n, maxBytes, func(maxBytes int64) (Cache, error) {
+		return NewLRUK(maxBytes, k, opts...)
+	})
+}
+
+// fnv-1a, without the allocation of hash/fnv
+func (s *Sharded) shardOf(key string) budgeted {
+	var h uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+func (s *Sharded) Get(key string) (Value, error) {
+	return s.shardOf(key).Get(key)
+}
+
+func (s *Sharded) Peek(key string) (Value, error) {
+	return s.shardOf(key).Peek(key)
+}
+
+func (s *Sharded) Put(key string, value Value) error {
+	return s.PutWithTTL(key, value, 0)
+}
+
+func (s *Sharded) PutWithTTL(key string, value Value, ttl time.Duration) error {
+	shard := s.shardOf(key)
+	if err := shard.PutWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+	s.reclaim(shard, key)
+	return nil
+}
+
+// evict the lru entries until the shards fit in the budget, the written shard
+// goes first, then the other shards give up theirs, one shard is locked at a time
+func (s *Sharded) reclaim(shard budgeted, key string) {
+	for s.used.Load() > s.maxBytes && shard.evictOldest(key) {
+	}
+	for i := 0; i < len(s.shards) && s.used.Load() > s.maxBytes; {
+		if s.shards[i] == shard || !s.shards[i].evictOldest(key) {
+			i++
+		}
+	}
+}
+
+func (s *Sharded) Delete(key string) error {
+	return s.shardOf(key).Delete(key)
+}
+
+func (s *Sharded) Keys() []string {
+	var keys []string
+	for _, shard := range s.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+func (s *Sharded) RemoveExpired() int {
+	removed := 0
+	for _, shard := range s.shards {
+		removed += shard.RemoveExpired()
+	}
+	return removed
+}
+
+// set the listener on the shards which report the removed entries
+func (s *Sharded) SetRemovalListener(listener RemovalListener) {
+	for _, shard := range s.shards {
+		if n, ok := Cache(shard).(Notifier); ok {
+			n.SetRemovalListener(listener)
+		}
+	}
+}
+
+// the sum of the shards
+func (s *Sharded) Stats() Stats {
+	var stats Stats
+	for _, shard := range s.shards {
+		stats.add(shard.Stats())
+	}
+	stats.MaxBytes = s.maxBytes
+	return stats
+}
+
+func (s *Sharded) ShardCount() int {
+	return len(s.shards)
+}
+
+func (s *Sharded) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Sharded(")
+	for i, shard := range s.shards {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		fmt.Fprintf(&buf, "%d: %v", i, shard)
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+func (s *Sharded) View() {
+	fmt.Println(s.String())
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestShardedSize(t *testing.T) {
+	if _, err := NewShardedLRU(0, 10); err == nil {
+		t.Fail()
+	}
+	if _, err := NewShardedLRU(4, 0); err == nil {
+		t.Fail()
+	}
+	if _, err := NewShardedLRUK(4, 10, 0); err == nil {
+		t.Fail()
+	}
+	if _, err := NewSharded(4, 10, func(maxBytes int64) (Cache, error) {
+		return NewTinyLFU(maxBytes)
+	}); err == nil {
+		t.Fatal("the shard must share the budget")
+	}
+	sharded, err := NewShardedLRU(3, 10)
+	if err != nil || sharded.ShardCount() != 3 {
+		t.Fatal(err)
+	}
+	// the entry bigger than maxBytes / n fits in the empty cache
+	if err := sharded.Put("1", String("123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sharded.Put("2", String("1234567890")); err == nil {
+		t.Fatal("the entry bigger than the budget must be rejected")
+	}
+}
+
+func TestShardedSharedBudget(t *testing.T) {
+	for _, sharded := range []func() (*Sharded, error){
+		func() (*Sharded, error) { return NewShardedLRU(4, 40) },
+		func() (*Sharded, error) { return NewShardedLRUK(4, 40, 2) },
+	} {
+		s, _ := sharded()
+		for i := 0; i < 100; i++ {
+			key, value := transformKeyAndValue(i, i)
+			if err := s.Put(key, value); err != nil {
+				t.Fatal(err)
+			}
+			if stats := s.Stats(); stats.Bytes > 40 || stats.Bytes != s.used.Load() {
+				t.Fatalf("the shards exceed the budget: %+v, used %d", stats, s.used.Load())
+			}
+		}
+		// the budget is full, the shards evicted their lru entries
+		stats := s.Stats()
+		if stats.Bytes < 36 || stats.Evictions == 0 || stats.MaxBytes != 40 {
+			t.Fatalf("%+v", stats)
+		}
+		for _, key := range s.Keys() {
+			s.Delete(key)
+		}
+		if s.used.Load() != 0 {
+			t.Fatalf("the deleted entries are still counted: %d", s.used.Load())
+		}
+	}
+}
+
+func TestShardedPutGet(t *testing.T) {
+	sharded, _ := NewShardedLRUK(4, 1<<10, 2)
+	for i := 0; i < 50; i++ {
+		key, value := transformKeyAndValue(i, i+1)
+		if err := sharded.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		key, value := transformKeyAndValue(i, i+1)
+		if v, err := sharded.Get(key); err != nil || v != value {
+			t.Fatalf("get %s: %v, %v", key, v, err)
+		}
+	}
+	// every key stays in the same shard
+	if err := sharded.Delete("1"); err != nil {
+		t.Fail()
+	}
+	if _, err := sharded.Get("1"); err == nil {
+		t.Fail()
+	}
+	keys := sharded.Keys()
+	if len(keys) != 49 {
+		t.Fatalf("%d keys in the cache", len(keys))
+	}
+	sort.Strings(keys)
+	if keys[0] != "0" || keys[1] != "10" {
+		t.Fail()
+	}
+}
+
+func TestShardedExpire(t *testing.T) {
+	sharded, _ := NewShardedLRU(4, 1<<10)
+	for i := 0; i < 10; i++ {
+		key, value := transformKeyAndValue(i, i)
+		sharded.PutWithTTL(key, value, 10*time.Millisecond)
+	}
+	sharded.Put("10", String("10"))
+	time.Sleep(20 * time.Millisecond)
+	if n := sharded.RemoveExpired(); n != 10 {
+		t.Fatalf("removed %d expired entries", n)
+	}
+	if keys := sharded.Keys(); len(keys) != 1 || keys[0] != "10" {
+		t.Fail()
+	}
+}
+
+// the parallel get and put on the hot keys
+func benchmarkParallel(b *testing.B, c Cache) {
+	for i := 0; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), String(strconv.Itoa(i)))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := strconv.Itoa(r.Intn(1000))
+			if r.Intn(10) == 0 {
+				c.Put(key, String(key))
+			} else {
+				c.Get(key)
+			}
+		}
+	})
+}
+
+func BenchmarkParallelLRU(b *testing.B) {
+	lru, _ := NewLRU(1 << 20)
+	benchmarkParallel(b, lru)
+}
+
+func BenchmarkParallelLRUK(b *testing.B) {
+	lruk, _ := NewLRUK(1<<20, 2)
+	benchmarkParallel(b, lruk)
+}
+
+func BenchmarkParallelShardedLRU(b *testing.B) {
+	sharded, _ := NewShardedLRU(16, 1<<20)
+	benchmarkParallel(b, sharded)
+}
+
+func BenchmarkParallelShardedLRUK(b *testing.B) {
+	sharded, _ := NewShardedLRUK(16, 1<<20, 2)
+	benchmarkParallel(b, sharded)
+}
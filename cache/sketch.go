@@ -0,0 +1,89 @@
+package cache
+
+const (
+	sketchDepth   = 4
+	maxFrequency  = 15 // the counter is saturated like the 4 bits one
+	sampleFactor  = 10 // the counters are halved after sampleFactor * width increments
+	minSketchSize = 64
+)
+
+// countMinSketch estimates the access frequency of the keys with
+// sketchDepth rows of counters, the estimation is the minimum of the rows,
+// all counters are halved periodically so the old popularity fades out
+type countMinSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint32
+	additions  int
+	sampleSize int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	s := &countMinSketch{}
+	s.resize(width)
+	return s
+}
+
+func (s *countMinSketch) resize(width int) {
+	size := minSketchSize
+	for size < width {
+		size <<= 1
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size)
+	}
+	s.mask = uint32(size - 1)
+	s.additions = 0
+	s.sampleSize = sampleFactor * size
+}
+
+// grow the sketch when the entries outnumber the counters, the counts are dropped
+func (s *countMinSketch) ensureCapacity(entries int) {
+	if entries > len(s.rows[0]) {
+		s.resize(entries)
+	}
+}
+
+// double hashing on the 64 bits fnv-1a of the key
+func (s *countMinSketch) indexes(key string) [sketchDepth]uint32 {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	var idx [sketchDepth]uint32
+	for i := range idx {
+		idx[i] = (h1 + uint32(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *countMinSketch) increment(key string) {
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < maxFrequency {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.age()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	var freq uint8 = maxFrequency
+	for i, idx := range s.indexes(key) {
+		freq = min(freq, s.rows[i][idx])
+	}
+	return freq
+}
+
+// halve all counters
+func (s *countMinSketch) age() {
+	for _, row := range s.rows {
+		for i := range row {
+			row[i] >>= 1
+		}
+	}
+	s.additions /= 2
+}
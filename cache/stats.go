@@ -0,0 +1,52 @@
+package cache
+
+// Stats is the snapshot of the cache counters
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// the entries removed to make room, the expired and deleted ones are not counted
+	Evictions int64 `json:"evictions"`
+	// the entries moved to the frequent part: lru1 to lru2 of LRU-k,
+	// probation to protected of TinyLFU, t1 to t2 of ARC
+	Promotions int64 `json:"promotions"`
+	Bytes      int64 `json:"bytes"`
+	MaxBytes   int64 `json:"max_bytes"`
+	Items      int   `json:"items"`
+}
+
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s *Stats) add(other Stats) {
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.Evictions += other.Evictions
+	s.Promotions += other.Promotions
+	s.Bytes += other.Bytes
+	s.MaxBytes += other.MaxBytes
+	s.Items += other.Items
+}
+
+// counters are updated under the lock of the cache
+type counters struct {
+	hits       int64
+	misses     int64
+	evictions  int64
+	promotions int64
+}
+
+func (c *counters) stats(nbytes int64, maxBytes int64, items int) Stats {
+	return Stats{
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+		Promotions: c.promotions,
+		Bytes:      nbytes,
+		MaxBytes:   maxBytes,
+		Items:      items,
+	}
+}
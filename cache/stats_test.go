@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUStats(t *testing.T) {
+	lru, _ := NewLRU(4)
+	lru.Put("1", String("1"))
+	lru.Put("2", String("2"))
+	lru.Get("1")
+	lru.Get("3")
+	// "2" is evicted
+	lru.Put("3", String("3"))
+	lru.Delete("1")
+	stats := lru.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Evictions != 1 || stats.Promotions != 0 ||
+		stats.Bytes != 2 || stats.MaxBytes != 4 || stats.Items != 1 || stats.HitRatio() != 0.5 {
+		t.Fatalf("%+v", stats)
+	}
+}
+
+func TestLRUKStats(t *testing.T) {
+	lruk, _ := NewLRUK(4, 2)
+	lruk.Put("1", String("1"))
+	lruk.Get("1")
+	lruk.Get("1")
+	lruk.Get("2")
+	lruk.Put("2", String("2"))
+	lruk.Put("3", String("3"))
+	stats := lruk.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 || stats.Promotions != 1 ||
+		stats.Bytes != 4 || stats.Items != 2 {
+		t.Fatalf("%+v", stats)
+	}
+}
+
+func TestPolicyStats(t *testing.T) {
+	for _, name := range []string{"tinylfu", "arc"} {
+		c, _ := NewPolicy(name, 100, 2)
+		for i := 0; i < 100; i++ {
+			key, value := transformKeyAndValue(i, i)
+			c.Put(key, value)
+			c.Get(key)
+		}
+		c.Get("none")
+		stats := c.Stats()
+		if stats.Hits+stats.Misses != 101 || stats.Misses == 0 || stats.Evictions == 0 || stats.Promotions == 0 ||
+			stats.Items != len(c.Keys()) || stats.Bytes > stats.MaxBytes {
+			t.Fatalf("%s: %+v", name, stats)
+		}
+	}
+}
+
+func TestShardedStats(t *testing.T) {
+	s, _ := NewShardedLRU(4, 100)
+	for i := 0; i < 10; i++ {
+		key, value := transformKeyAndValue(i, i)
+		s.Put(key, value)
+		s.Get(key)
+	}
+	stats := s.Stats()
+	if stats.Hits != 10 || stats.Items != 10 || stats.Bytes != 20 || stats.MaxBytes != 100 {
+		t.Fatalf("%+v", stats)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	for _, name := range Policies() {
+		c, _ := NewPolicy(name, 100, 2)
+		c.Put("1", String("1"))
+		c.PutWithTTL("2", String("2"), time.Nanosecond)
+		time.Sleep(time.Millisecond)
+		for i := 0; i < 3; i++ {
+			if v, err := c.Peek("1"); err != nil || string(v.Bytes()) != "1" {
+				t.Fatalf("%s: peek %v %v", name, v, err)
+			}
+			if _, err := c.Peek("2"); err == nil {
+				t.Fatalf("%s: the expired entry must not be peeked", name)
+			}
+			if _, err := c.Peek("3"); err == nil {
+				t.Fatalf("%s: the missing entry must not be peeked", name)
+			}
+		}
+		if stats := c.Stats(); stats.Hits != 0 || stats.Misses != 0 || stats.Promotions != 0 {
+			t.Fatalf("%s: the peeks must not be counted %+v", name, stats)
+		}
+	}
+}
+
+func TestPeekKeepsEvictionOrder(t *testing.T) {
+	lru, _ := NewLRU(4)
+	lru.Put("1", String("1"))
+	lru.Put("2", String("2"))
+	lru.Peek("1")
+	lru.Put("3", String("3"))
+	if _, err := lru.Peek("1"); err == nil {
+		t.Fatal("the peeked entry must stay the victim")
+	}
+	lruk, _ := NewLRUK(4, 1)
+	lruk.Put("1", String("1"))
+	lruk.Peek("1")
+	lruk.Peek("1")
+	if lruk.Getl2CurrentBytes() != 0 {
+		t.Fatal("the peeks must not promote the entry")
+	}
+}
@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"bytes"
+	"distributed_cache/common"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	windowPercent    = 1  // the window takes 1% of the bytes
+	protectedPercent = 80 // the protected segment takes 80% of the main bytes
+)
+
+// TinyLFU is the W-TinyLFU cache:
+// the new entries enter the small window lru, the window victim is admitted
+// into the main segmented lru only if it is accessed more frequently than
+// the main victim, so the one-off scans can't push out the hot entries
+type TinyLFU struct {
+	maxBytes     int64
+	windowMax    int64
+	protectedMax int64
+	window       *LRU
+	probation    *LRU // the main entries accessed once since admitted
+	protected    *LRU // the main entries accessed again in probation
+	segment      map[string]*LRU
+	sketch       *countMinSketch
+	counters
+	sync.Mutex
+}
+
+func NewTinyLFU(maxBytes int64) (*TinyLFU, error) {
+	if maxBytes <= 1 {
+		return &TinyLFU{}, common.ErrPositiveParamNegative
+	}
+	windowMax := max(maxBytes*windowPercent/100, 1)
+	t := &TinyLFU{
+		maxBytes:     maxBytes,
+		windowMax:    windowMax,
+		protectedMax: (maxBytes - windowMax) * protectedPercent / 100,
+		segment:      make(map[string]*LRU),
+		sketch:       newCountMinSketch(minSketchSize),
+	}
+	// the segments never evict by themselves
+	t.window, _ = NewLRU(math.MaxInt64)
+	t.probation, _ = NewLRU(math.MaxInt64)
+	t.protected, _ = NewLRU(math.MaxInt64)
+	return t, nil
+}
+
+func (t *TinyLFU) mainMax() int64 {
+	return t.maxBytes - t.windowMax
+}
+
+func (t *TinyLFU) mainBytes() int64 {
+	return t.probation.nbytes + t.protected.nbytes
+}
+
+// the probation victim goes first
+func (t *TinyLFU) mainVictim() *linkedNode {
+	if !t.probation.IsEmpty() {
+		return t.probation.getVictim()
+	}
+	if !t.protected.IsEmpty() {
+		return t.protected.getVictim()
+	}
+	return nil
+}
+
+func (t *TinyLFU) remove(key string) {
+	t.segment[key].remove(key)
+	delete(t.segment, key)
+}
+
+func (t *TinyLFU) Get(key string) (Value, error) {
+	t.Lock()
+	defer t.Unlock()
+	// the misses count as well, the frequency decides the admission
+	t.sketch.increment(key)
+	seg, ok := t.segment[key]
+	if !ok {
+		t.misses++
+		return nil, common.ErrKeyNotInCache
+	}
+	// lazy expiration
+	if seg.key2node[key].expired(time.Now()) {
+		t.remove(key)
+		t.misses++
+		return nil, common.ErrKeyNotInCache
+	}
+	t.hits++
+	node := seg.get(key)
+	if seg == t.probation {
+		t.promote(node)
+	}
+	return node.value, nil
+}
+
+// the sketch is not incremented, the peek is not an access
+func (t *TinyLFU) Peek(key string) (Value, error) {
+	t.Lock()
+	defer t.Unlock()
+	seg, ok := t.segment[key]
+	if !ok {
+		return nil, common.ErrKeyNotInCache
+	}
+	return seg.peek(key, time.Now())
+}
+
+// move the probation entry to the protected segment,
+// the protected victims are demoted to the probation
+func (t *TinyLFU) promote(node *linkedNode) {
+	t.probation.remove(node.key)
+	t.protected.put(node.key, node.value, node.expire)
+	t.segment[node.key] = t.protected
+	t.promotions++
+	for t.protected.nbytes > t.protectedMax {
+		victim := t.protected.getVictim()
+		t.protected.remove(victim.key)
+		t.probation.put(victim.key, victim.value, victim.expire)
+		t.segment[victim.key] = t.probation
+	}
+}
+
+func (t *TinyLFU) Put(key string, value Value) error {
+	return t.PutWithTTL(key, value, 0)
+}
+
+// the entry must fit in the main segments
+func (t *TinyLFU) PutWithTTL(key string, value Value, ttl time.Duration) error {
+	t.Lock()
+	defer t.Unlock()
+	if entrySize(key, value) > t.mainMax() {
+		return common.ErrCacheCapacityNotEnough
+	}
+	t.sketch.increment(key)
+	expire := expireAt(ttl)
+	// key in cache, update the value in place
+	if seg, ok := t.segment[key]; ok {
+		seg.put(key, value, expire)
+		t.evictWindow()
+		for t.mainBytes() > t.mainMax() {
+			t.remove(t.mainVictim().key)
+			t.evictions++
+		}
+		return nil
+	}
+	t.window.put(key, value, expire)
+	t.segment[key] = t.window
+	t.sketch.ensureCapacity(len(t.segment))
+	t.evictWindow()
+	return nil
+}
+
+// the window victims are the candidates of the main segments
+func (t *TinyLFU) evictWindow() {
+	for t.window.nbytes > t.windowMax {
+		candidate := t.window.getVictim()
+		t.remove(candidate.key)
+		t.admit(candidate)
+	}
+}
+
+// the candidate replaces the main victims only if it is more frequent
+func (t *TinyLFU) admit(candidate *linkedNode) {
+	size := entrySize(candidate.key, candidate.value)
+	for t.mainBytes()+size > t.mainMax() {
+		victim := t.mainVictim()
+		if t.sketch.estimate(candidate.key) <= t.sketch.estimate(victim.key) {
+			// the candidate is rejected
+			t.evictions++
+			return
+		}
+		t.remove(victim.key)
+		t.evictions++
+	}
+	t.probation.put(candidate.key, candidate.value, candidate.expire)
+	t.segment[candidate.key] = t.probation
+}
+
+func (t *TinyLFU) Delete(key string) error {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.segment[key]; !ok {
+		return common.ErrKeyNotInCache
+	}
+	t.remove(key)
+	return nil
+}
+
+func (t *TinyLFU) Keys() []string {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	keys := t.protected.keys(now)
+	keys = append(keys, t.probation.keys(now)...)
+	return append(keys, t.window.keys(now)...)
+}
+
+func (t *TinyLFU) RemoveExpired() int {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	nodes := t.window.expiredNodes(now)
+	nodes = append(nodes, t.probation.expiredNodes(now)...)
+	nodes = append(nodes, t.protected.expiredNodes(now)...)
+	for _, node := range nodes {
+		t.remove(node.key)
+	}
+	return len(nodes)
+}
+
+func (t *TinyLFU) GetCurrentBytes() int64 {
+	return t.window.nbytes + t.mainBytes()
+}
+
+func (t *TinyLFU) Stats() Stats {
+	t.Lock()
+	defer t.Unlock()
+	return t.stats(t.GetCurrentBytes(), t.maxBytes, len(t.segment))
+}
+
+func (t *TinyLFU) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("W-TinyLFU(")
+	buf.WriteString("window: ")
+	buf.WriteString(t.window.String())
+	buf.WriteString(" probation: ")
+	buf.WriteString(t.probation.String())
+	buf.WriteString(" protected: ")
+	buf.WriteString(t.protected.String())
+	buf.WriteString(")")
+	return buf.String()
+}
+
+func (t *TinyLFU) View() {
+	fmt.Println(t.String())
+}
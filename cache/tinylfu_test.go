@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTinyLFUSize(t *testing.T) {
+	if _, err := NewTinyLFU(1); err == nil {
+		t.Fail()
+	}
+	tiny, err := NewTinyLFU(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tiny.windowMax != 1 || tiny.mainMax() != 99 || tiny.protectedMax != 79 {
+		t.Fail()
+	}
+	if err := tiny.Put("1", String(make([]byte, 99))); err == nil {
+		t.Fatal("the entry bigger than the main segments must fail")
+	}
+}
+
+func TestTinyLFUPutGet(t *testing.T) {
+	tiny, _ := NewTinyLFU(100)
+	for i := 0; i < 10; i++ {
+		key, value := transformKeyAndValue(i, i)
+		tiny.Put(key, value)
+	}
+	for i := 0; i < 10; i++ {
+		key, value := transformKeyAndValue(i, i)
+		if v, err := tiny.Get(key); err != nil || v != value {
+			t.Fatalf("get %s: %v, %v", key, v, err)
+		}
+	}
+	// the entries accessed again in probation are protected
+	if tiny.segment["0"] != tiny.protected || tiny.GetCurrentBytes() != 20 {
+		t.Fail()
+	}
+	tiny.Put("0", String("00"))
+	if v, _ := tiny.Get("0"); v != String("00") || tiny.GetCurrentBytes() != 21 {
+		t.Fail()
+	}
+	if err := tiny.Delete("0"); err != nil || tiny.Delete("0") == nil {
+		t.Fail()
+	}
+	if len(tiny.Keys()) != 9 {
+		t.Fail()
+	}
+}
+
+func TestTinyLFUScanResistant(t *testing.T) {
+	tiny, _ := NewTinyLFU(200)
+	// 10 hot entries of 4 bytes
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("h%02d", i)
+			if _, err := tiny.Get(key); err != nil {
+				tiny.Put(key, String("v"))
+			}
+		}
+	}
+	// the one-off scan is much bigger than the cache
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("s%02d", i)
+		if _, err := tiny.Get(key); err != nil {
+			tiny.Put(key, String("v"))
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := tiny.Get(fmt.Sprintf("h%02d", i)); err != nil {
+			t.Fatalf("the hot key h%02d is pushed out by the scan", i)
+		}
+	}
+	if tiny.GetCurrentBytes() > 200 {
+		t.Fail()
+	}
+}
+
+func TestTinyLFUExpire(t *testing.T) {
+	tiny, _ := NewTinyLFU(100)
+	tiny.PutWithTTL("1", String("1"), 10*time.Millisecond)
+	tiny.PutWithTTL("2", String("2"), 10*time.Millisecond)
+	tiny.Put("3", String("3"))
+	time.Sleep(20 * time.Millisecond)
+	if _, err := tiny.Get("1"); err == nil {
+		t.Fail()
+	}
+	if n := tiny.RemoveExpired(); n != 1 {
+		t.Fail()
+	}
+	if keys := tiny.Keys(); len(keys) != 1 || keys[0] != "3" {
+		t.Fail()
+	}
+}
+
+func TestSketch(t *testing.T) {
+	sketch := newCountMinSketch(0)
+	for i := 0; i < 20; i++ {
+		sketch.increment("hot")
+	}
+	sketch.increment("cold")
+	if sketch.estimate("hot") != maxFrequency || sketch.estimate("cold") != 1 || sketch.estimate("none") != 0 {
+		t.Fail()
+	}
+	sketch.age()
+	if sketch.estimate("hot") != maxFrequency/2 || sketch.estimate("cold") != 0 {
+		t.Fail()
+	}
+	sketch.ensureCapacity(1000)
+	if len(sketch.rows[0]) != 1024 || sketch.sampleSize != 10240 {
+		t.Fail()
+	}
+}
+
+const (
+	traceKeys    = 100000
+	traceLength  = 200000
+	traceEntries = 1000 // the cache holds 1000 entries
+	traceBytes   = traceEntries * 9
+)
+
+func traceKey(i int) string {
+	return fmt.Sprintf("%08d", i)
+}
+
+func zipfTrace() []string {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, traceKeys-1)
+	trace := make([]string, traceLength)
+	for i := range trace {
+		trace[i] = traceKey(int(zipf.Uint64()))
+	}
+	return trace
+}
+
+// the zipf accesses interleaved with the scans of the keys never seen again
+func scanTrace() []string {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, traceKeys-1)
+	trace := make([]string, 0, traceLength)
+	scanned := traceKeys
+	for len(trace) < traceLength {
+		for i := 0; i < 2000; i++ {
+			trace = append(trace, traceKey(int(zipf.Uint64())))
+		}
+		for i := 0; i < 2000; i++ {
+			trace = append(trace, traceKey(scanned))
+			scanned++
+		}
+	}
+	return trace
+}
+
+// replay the trace, load the value on every miss
+func hitRatio(c Cache, trace []string) float64 {
+	hits := 0
+	for _, key := range trace {
+		if _, err := c.Get(key); err == nil {
+			hits++
+			continue
+		}
+		c.Put(key, String("v"))
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+func benchmarkHitRatio(b *testing.B, trace []string, newCache func() Cache) {
+	var ratio float64
+	for i := 0; i < b.N; i++ {
+		ratio = hitRatio(newCache(), trace)
+	}
+	b.ReportMetric(ratio*100, "hit%")
+}
+
+func BenchmarkHitRatio(b *testing.B) {
+	traces := map[string][]string{
+		"zipf": zipfTrace(),
+		"scan": scanTrace(),
+	}
+	policies := map[string]func() Cache{
+		"LRU": func() Cache {
+			c, _ := NewLRU(traceBytes)
+			return c
+		},
+		"LRUK": func() Cache {
+			c, _ := NewLRUK(traceBytes, 2)
+			return c
+		},
+		"TinyLFU": func() Cache {
+			c, _ := NewTinyLFU(traceBytes)
+			return c
+		},
+		"ARC": func() Cache {
+			c, _ := NewARC(traceBytes)
+			return c
+		},
+	}
+	for _, traceName := range []string{"zipf", "scan"} {
+		for _, policy := range []string{"LRU", "LRUK", "TinyLFU", "ARC"} {
+			b.Run(traceName+"/"+policy, func(b *testing.B) {
+				benchmarkHitRatio(b, traces[traceName], policies[policy])
+			})
+		}
+	}
+}
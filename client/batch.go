@@ -0,0 +1,66 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"distributed_cache/common"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// GetMultiRequest asks the cache node for the values of many keys of the service
+type GetMultiRequest struct {
+	Service string   `json:"service"`
+	Keys    []string `json:"keys"`
+}
+
+// GetMultiResult has the values of the keys found,
+// Error is the first error of the others
+type GetMultiResult struct {
+	Values map[string][]byte `json:"values"`
+	Error  string            `json:"error,omitempty"`
+}
+
+func (c *Client) GetMulti(serviceName string, keys []string) (map[string][]byte, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	return c.GetMultiContext(ctx, serviceName, keys)
+}
+
+// GetMultiContext loads the keys in one round trip, the keys not in the db
+// are missing in the result, the values found are returned with the error
+func (c *Client) GetMultiContext(ctx context.Context, serviceName string, keys []string) (map[string][]byte, error) {
+	c.log("Client [GET_MULTI]: service[%s] %d keys", serviceName, len(keys))
+	url, err := c.rootURL(common.BatchPath)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(GetMultiRequest{Service: serviceName, Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		c.log("request from %s error %s", url, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.log("Client [ERROR] response status: %s", resp.Status)
+		return nil, errors.New(resp.Status)
+	}
+	var result GetMultiResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return result.Values, errors.New(result.Error)
+	}
+	return result.Values, nil
+}
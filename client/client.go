@@ -1,22 +1,34 @@
 package client
 
 import (
+	"bytes"
+	"context"
 	"distributed_cache/common"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 type Client struct {
 	serverAddr string
+	forwarded  bool // the requests are forwarded by a peer
 }
 
 func NewClient(addr string) *Client {
 	return &Client{serverAddr: addr}
 }
 
+// NewPeerClient creates the client of the peer-to-peer mode,
+// its requests are marked as forwarded, so the peer serves them locally
+func NewPeerClient(addr string) *Client {
+	return &Client{serverAddr: addr, forwarded: true}
+}
+
 func (c *Client) log(format string, v ...any) {
 	if common.DEBUG {
 		log.Printf(format, v...)
@@ -27,21 +39,184 @@ func (c *Client) ServerAddr() string {
 	return c.serverAddr
 }
 
+// send the request with the context, the remaining budget of the context
+// is carried to the node in the deadline header
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(common.DeadlineHeader, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	}
+	if c.forwarded {
+		req.Header.Set(common.ForwardedHeader, "1")
+	}
+	return http.DefaultClient.Do(req.WithContext(ctx))
+}
+
+// the requests made without a context time out after common.RequestTimeout
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), common.RequestTimeout)
+}
+
 func (c *Client) Get(serviceName string, key string) ([]byte, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	return c.GetContext(ctx, serviceName, key)
+}
+
+func (c *Client) GetContext(ctx context.Context, serviceName string, key string) ([]byte, error) {
+	value, _, err := c.GetVersionedContext(ctx, serviceName, key)
+	return value, err
+}
+
+// GetVersioned returns the value with its write version
+func (c *Client) GetVersioned(serviceName string, key string) ([]byte, uint64, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	return c.GetVersionedContext(ctx, serviceName, key)
+}
+
+// GetVersionedContext implements service.PeerGetterContext,
+// the node returns common.ErrTimeout once the deadline of the context elapses
+func (c *Client) GetVersionedContext(ctx context.Context, serviceName string, key string) ([]byte, uint64, error) {
 	c.log("Client [GET]: service[%s] key[%s]", serviceName, key)
 	url := fmt.Sprintf("%v%v/%v", c.serverAddr, serviceName, key)
-	resp, err := http.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		c.log("request from %s error %s", url, err)
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		version, _ := strconv.ParseUint(resp.Header.Get(common.VersionHeader), 10, 64)
+		bytes, err := io.ReadAll(resp.Body)
+		return bytes, version, err
+	case resp.StatusCode == http.StatusNotFound && resp.Header.Get(common.NotFoundHeader) != "":
+		return nil, 0, common.ErrKeyNotInDB
+	case resp.StatusCode == http.StatusGatewayTimeout:
+		return nil, 0, common.ErrTimeout
+	default:
+		c.log("Client [ERROR] response status: %s", resp.Status)
+		return nil, 0, errors.New(resp.Status)
+	}
+}
+
+func (c *Client) Put(serviceName string, key string, value []byte) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	return c.PutContext(ctx, serviceName, key, value)
+}
+
+func (c *Client) PutContext(ctx context.Context, serviceName string, key string, value []byte) error {
+	return c.put(ctx, serviceName, key, value, "")
+}
+
+// PutVersioned writes the value with its version,
+// the cache node ignores the write if it has a newer version
+func (c *Client) PutVersioned(serviceName string, key string, value []byte, version uint64) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	return c.PutVersionedContext(ctx, serviceName, key, value, version)
+}
+
+func (c *Client) PutVersionedContext(ctx context.Context, serviceName string, key string, value []byte, version uint64) error {
+	return c.put(ctx, serviceName, key, value, strconv.FormatUint(version, 10))
+}
+
+// Populate writes the value into the cache of the node only, without calling the putter
+func (c *Client) Populate(serviceName string, key string, value []byte, version uint64) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	return c.PopulateContext(ctx, serviceName, key, value, version)
+}
+
+func (c *Client) PopulateContext(ctx context.Context, serviceName string, key string, value []byte, version uint64) error {
+	return c.put(ctx, serviceName, key, value, strconv.FormatUint(version, 10), common.CacheOnlyHeader)
+}
+
+func (c *Client) put(ctx context.Context, serviceName string, key string, value []byte, version string, headers ...string) error {
+	c.log("Client [PUT]: service[%s] key[%s]", serviceName, key)
+	url := fmt.Sprintf("%v%v/%v", c.serverAddr, serviceName, key)
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	if version != "" {
+		req.Header.Set(common.VersionHeader, version)
+	}
+	for _, header := range headers {
+		req.Header.Set(header, "1")
+	}
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		c.log("request from %s error %s", url, err)
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 	switch resp.StatusCode {
 	case http.StatusOK:
-		bytes, err := io.ReadAll(resp.Body)
-		return bytes, err
+		return nil
+	default:
+		c.log("Client [ERROR] response status: %s", resp.Status)
+		return errors.New(resp.Status)
+	}
+}
+
+func (c *Client) Delete(serviceName string, key string) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	return c.DeleteContext(ctx, serviceName, key)
+}
+
+func (c *Client) DeleteContext(ctx context.Context, serviceName string, key string) error {
+	c.log("Client [DELETE]: service[%s] key[%s]", serviceName, key)
+	url := fmt.Sprintf("%v%v/%v", c.serverAddr, serviceName, key)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		c.log("request from %s error %s", url, err)
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
 	default:
 		c.log("Client [ERROR] response status: %s", resp.Status)
-		return nil, errors.New(resp.Status)
+		return errors.New(resp.Status)
+	}
+}
+
+// url of the path on the cache node
+func (c *Client) rootURL(path string) (string, error) {
+	u, err := url.Parse(c.serverAddr)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path
+	return u.String(), nil
+}
+
+// probe the health endpoint of the cache node
+func (c *Client) Health(timeout time.Duration) error {
+	url, err := c.rootURL(common.HealthPath)
+	if err != nil {
+		return err
+	}
+	httpClient := http.Client{Timeout: timeout}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
 	}
+	return nil
 }
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bytes"
+	"distributed_cache/common"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// MasterClient is used by the cache node to (de)register itself on the master
+type MasterClient struct {
+	clusterAddr string // e.g. http://localhost:9999/cluster/nodes
+}
+
+func NewMasterClient(addr string) *MasterClient {
+	return &MasterClient{clusterAddr: addr}
+}
+
+func (c *MasterClient) log(format string, v ...any) {
+	if common.DEBUG {
+		log.Printf(format, v...)
+	}
+}
+
+func (c *MasterClient) Register(self string) error {
+	body, _ := json.Marshal(map[string]string{"addr": self})
+	resp, err := http.Post(c.clusterAddr, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusConflict:
+		return common.ErrPeerRegistered
+	default:
+		return errors.New(resp.Status)
+	}
+}
+
+// keep trying to register until success or the master reports that
+// the node was already registered
+func (c *MasterClient) RegisterWithRetry(self string, interval time.Duration, stop <-chan struct{}) error {
+	for {
+		err := c.Register(self)
+		if err == nil || err == common.ErrPeerRegistered {
+			c.log("MasterClient: node %s registered on %s", self, c.clusterAddr)
+			return nil
+		}
+		c.log("MasterClient [ERROR]: register node %s on %s error %s, retry after %v", self, c.clusterAddr, err, interval)
+		select {
+		case <-stop:
+			return err
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c *MasterClient) Deregister(self string) error {
+	url := fmt.Sprintf("%v/%v", c.clusterAddr, self)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return common.ErrPeerNotRegistered
+	default:
+		return errors.New(resp.Status)
+	}
+}
+
+func (c *MasterClient) Nodes() ([]string, error) {
+	resp, err := http.Get(c.clusterAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	var addrs []string
+	err = json.NewDecoder(resp.Body).Decode(&addrs)
+	return addrs, err
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"distributed_cache/common"
+	"distributed_cache/consistenthash"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// MigrateRequest asks the cache node to copy the entries whose key hash
+// is in the ranges to the targets, then evict them locally if required
+type MigrateRequest struct {
+	Ranges  []consistenthash.Range `json:"ranges"`
+	Targets []string               `json:"targets"` // server addr of the target nodes, e.g. http://localhost:8001/_Cache/
+	Evict   bool                   `json:"evict"`
+	Rate    int                    `json:"rate"` // max entries per second, <= 0 means unlimited
+	// the services migrated, empty means all the services except the skipped ones
+	Services []string `json:"services,omitempty"`
+	Skip     []string `json:"skip,omitempty"`
+}
+
+// whether the entries of the service are migrated
+func (m MigrateRequest) Includes(serviceName string) bool {
+	for _, name := range m.Skip {
+		if name == serviceName {
+			return false
+		}
+	}
+	if len(m.Services) == 0 {
+		return true
+	}
+	for _, name := range m.Services {
+		if name == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+type MigrateResult struct {
+	Moved   int `json:"moved"`
+	Evicted int `json:"evicted"`
+	Failed  int `json:"failed"`
+}
+
+func (c *Client) Migrate(migrate MigrateRequest) (MigrateResult, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	return c.MigrateContext(ctx, migrate)
+}
+
+// MigrateContext bounds the migration by the deadline of the context,
+// the node stops copying the entries once it elapses
+func (c *Client) MigrateContext(ctx context.Context, migrate MigrateRequest) (MigrateResult, error) {
+	var result MigrateResult
+	c.log("Client [MIGRATE]: %d ranges to %v, evict %v", len(migrate.Ranges), migrate.Targets, migrate.Evict)
+	url, err := c.rootURL(common.MigratePath)
+	if err != nil {
+		return result, err
+	}
+	body, err := json.Marshal(migrate)
+	if err != nil {
+		return result, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		c.log("request from %s error %s", url, err)
+		return result, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.log("Client [ERROR] response status: %s", resp.Status)
+		return result, errors.New(resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return result, err
+}
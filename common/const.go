@@ -4,3 +4,41 @@ import "time"
 
 var TimeoutInterval = 100 * time.Millisecond
 var CacheCapacity = 2 << 8
+var JanitorInterval = time.Second
+var RegisterRetryInterval = time.Second
+
+// timeout of the client request made without a context
+var RequestTimeout = 5 * time.Second
+
+// path of the health check endpoint on the cache node
+var HealthPath = "/health"
+
+// header which carries the write version of the value
+var VersionHeader = "X-Cache-Version"
+
+// header which marks the write only updates the cache, without calling the putter
+var CacheOnlyHeader = "X-Cache-Only"
+
+// header which marks the 404 response of the key not in the db,
+// to tell it from the 404 of the unknown service
+var NotFoundHeader = "X-Cache-Not-Found"
+
+// header which carries the remaining budget of the request in milliseconds,
+// the node bounds its load by it
+var DeadlineHeader = "X-Cache-Deadline"
+
+// header which marks the request forwarded by a peer,
+// the node serves it locally without forwarding it again
+var ForwardedHeader = "X-Cache-Forwarded"
+
+// path of the migration endpoint on the cache node
+var MigratePath = "/_migrate"
+
+// path of the multi-get endpoint on the cache node
+var BatchPath = "/_batch"
+
+// path of the statistics endpoint on the cache node
+var StatsPath = "/_stats"
+
+// path of the prometheus metrics endpoint on the cache node and the master
+var MetricsPath = "/metrics"
@@ -12,10 +12,16 @@ var (
 	ErrKeyNotInDB             = errors.New("key not in db")
 	ErrKeyNotInCache          = errors.New("key not in cache")
 	ErrCacheCapacityNotEnough = errors.New("new entry is bigger than cache capacity")
+	ErrPolicyNotRegistered    = errors.New("eviction policy is not registered")
+	ErrListenerNotSupported   = errors.New("cache does not report the removed entries")
+	ErrBudgetNotSupported     = errors.New("cache can not share the byte budget")
 	//
 	ErrServiceNotExisted = errors.New("service is not existed")
 	//
 	ErrPeerRegistered    = errors.New("peer was already registered")
 	ErrPeerNotRegistered = errors.New("peer is never registered")
 	ErrNoPeerRegistered  = errors.New("no peer was registered")
+	ErrQuorumNotReached  = errors.New("not enough replicas responded")
+	//
+	ErrNotLeader = errors.New("not the leader")
 )
@@ -0,0 +1,119 @@
+package consistenthash
+
+import "sort"
+
+// Range is the arc (Start, End] of the hash ring,
+// Start >= End means the arc wraps around 0
+type Range struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+}
+
+func (r Range) Contains(hashValue uint32) bool {
+	if r.Start < r.End {
+		return r.Start < hashValue && hashValue <= r.End
+	}
+	return hashValue > r.Start || hashValue <= r.End
+}
+
+// Move is the hash range whose owners changed
+type Move struct {
+	Range
+	From []string // owners in the old map
+	To   []string // owners in the new map
+}
+
+func (m *Map) Clone() *Map {
+	clone := NewMap(m.replicas, m.hash)
+	clone.hashValues = append(clone.hashValues, m.hashValues...)
+	for hashValue, peer := range m.hash2peer {
+		clone.hash2peer[hashValue] = peer
+	}
+	for peer := range m.peers {
+		clone.peers[peer] = struct{}{}
+	}
+	return clone
+}
+
+// at most n distinct peers clockwise from the hash value
+func (m *Map) searchNHash(hashValue int, n int) []string {
+	var peers []string
+	seen := make(map[string]struct{}, n)
+	idx := m.searchIdx(hashValue)
+	for i := 0; i < len(m.hashValues) && len(peers) < n; i++ {
+		// skip the virtual peers of the same peer
+		peer := m.hash2peer[m.hashValues[(idx+i)%len(m.hashValues)]]
+		if _, ok := seen[peer]; ok {
+			continue
+		}
+		seen[peer] = struct{}{}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Diff returns the hash ranges whose n owners changed from the old map to the new map,
+// both maps must use the same hash function
+func Diff(old *Map, new *Map, n int) []Move {
+	if old.Empty() || new.Empty() {
+		return nil
+	}
+	// the virtual peers of both maps split the ring into arcs,
+	// every hash value in an arc has the same owners
+	points := append(append([]int{}, old.hashValues...), new.hashValues...)
+	sort.Ints(points)
+	var uniq []int
+	for i, point := range points {
+		if i == 0 || point != points[i-1] {
+			uniq = append(uniq, point)
+		}
+	}
+	var moves []Move
+	for i, end := range uniq {
+		start := uniq[(i-1+len(uniq))%len(uniq)]
+		from, to := old.searchNHash(end, n), new.searchNHash(end, n)
+		if sameSet(from, to) {
+			continue
+		}
+		// merge with the previous adjacent arc
+		if last := len(moves) - 1; last >= 0 && moves[last].End == uint32(start) &&
+			sameList(moves[last].From, from) && sameList(moves[last].To, to) {
+			moves[last].End = uint32(end)
+			continue
+		}
+		moves = append(moves, Move{
+			Range: Range{Start: uint32(start), End: uint32(end)},
+			From:  from,
+			To:    to,
+		})
+	}
+	return moves
+}
+
+func sameList(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameSet(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]struct{}, len(a))
+	for _, peer := range a {
+		set[peer] = struct{}{}
+	}
+	for _, peer := range b {
+		if _, ok := set[peer]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package consistenthash
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func newNumberMap() *Map {
+	return NewMap(3, func(data []byte) uint32 {
+		i, _ := strconv.Atoi(string(data))
+		return uint32(i)
+	})
+}
+
+func TestRangeContains(t *testing.T) {
+	testCases := []struct {
+		r   Range
+		h   uint32
+		ans bool
+	}{
+		{Range{10, 20}, 15, true},
+		{Range{10, 20}, 10, false},
+		{Range{10, 20}, 20, true},
+		{Range{20, 10}, 25, true},
+		{Range{20, 10}, 5, true},
+		{Range{20, 10}, 15, false},
+		{Range{10, 10}, 5, true},
+	}
+	for _, c := range testCases {
+		if c.r.Contains(c.h) != c.ans {
+			fmt.Printf("range %+v contains %d must be %v\n", c.r, c.h, c.ans)
+			t.Fail()
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := newNumberMap()
+	// 11, 12, 13, 21, 22, 23, 31, 32, 33
+	old.Add("1", "2", "3")
+	new := old.Clone()
+	// 41, 42, 43
+	new.Add("4")
+	if old.PeerCount() != 3 {
+		t.Fatal("the clone must not change the old map")
+	}
+
+	moves := Diff(old, new, 1)
+	ans := []Move{{Range{33, 43}, []string{"1"}, []string{"4"}}}
+	if !reflect.DeepEqual(moves, ans) {
+		t.Fatalf("get moves %+v, should be %+v", moves, ans)
+	}
+
+	moves = Diff(old, new, 2)
+	ans = []Move{
+		{Range{23, 33}, []string{"3", "1"}, []string{"3", "4"}},
+		{Range{33, 43}, []string{"1", "2"}, []string{"4", "1"}},
+	}
+	if !reflect.DeepEqual(moves, ans) {
+		t.Fatalf("get moves %+v, should be %+v", moves, ans)
+	}
+
+	// every key in the moved range is owned by the new peer
+	for i := 34; i <= 43; i++ {
+		if peer, _ := new.Search(strconv.Itoa(i)); peer != "4" {
+			t.Fail()
+		}
+	}
+
+	if moves := Diff(new, old, 1); len(moves) != 1 || moves[0].From[0] != "4" {
+		t.Fail()
+	}
+	if moves := Diff(old, old.Clone(), 1); len(moves) != 0 {
+		t.Fail()
+	}
+}
@@ -9,6 +9,9 @@ import (
 
 type HashFunc func(data []byte) uint32
 
+// the hash function used when no one is given
+var DefaultHash HashFunc = crc32.ChecksumIEEE
+
 type Map struct {
 	hash       HashFunc
 	replicas   int                 // virtual peer num
@@ -19,7 +22,7 @@ type Map struct {
 
 func NewMap(replicas int, hash HashFunc) *Map {
 	if hash == nil {
-		hash = crc32.ChecksumIEEE
+		hash = DefaultHash
 	}
 	return &Map{
 		hash:      hash,
@@ -101,6 +104,19 @@ func (m *Map) Search(key string) (string, error) {
 	return m.hash2peer[virtualHashValue], nil
 }
 
+// SearchN walks the ring clockwise from the key, returns at most n distinct peers,
+// the first one is the same as Search
+func (m *Map) SearchN(key string, n int) ([]string, error) {
+	if m.Empty() {
+		return nil, common.ErrNoPeerRegistered
+	}
+	if n > m.PeerCount() {
+		n = m.PeerCount()
+	}
+	value := m.hash([]byte(key))
+	return m.searchNHash(int(value), n), nil
+}
+
 func (m *Map) PeerCount() int {
 	return len(m.peers)
 }
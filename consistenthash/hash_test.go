@@ -3,6 +3,7 @@ package consistenthash
 import (
 	"fmt"
 	"math/rand"
+	"reflect"
 	"strconv"
 	"sync"
 	"testing"
@@ -83,6 +84,39 @@ func TestConsistenthashSearch(t *testing.T) {
 	// fmt.Printf("%+v\n", peerMap)
 }
 
+func TestConsistenthashSearchN(t *testing.T) {
+	peerMap := NewMap(3, func(data []byte) uint32 {
+		i, _ := strconv.Atoi(string(data))
+		return uint32(i)
+	})
+	// 11, 12, 13, 21, 22, 23, 31, 32, 33
+	peerMap.Add("1", "2", "3")
+	testCases := map[string][]string{
+		"20": {"2", "3", "1"},
+		"10": {"1", "2", "3"},
+		"34": {"1", "2", "3"},
+		"30": {"3", "1", "2"},
+	}
+	for key, ans := range testCases {
+		res, err := peerMap.SearchN(key, 3)
+		if err != nil || !reflect.DeepEqual(res, ans) {
+			fmt.Printf("the key [%s] get the wrong answer %v, should be %v\n", key, res, ans)
+			t.Fail()
+			break
+		}
+		if first, _ := peerMap.Search(key); first != res[0] {
+			t.Fail()
+		}
+	}
+	if res, _ := peerMap.SearchN("20", 5); len(res) != 3 {
+		fmt.Println("the result must not be more than the peer count")
+		t.Fail()
+	}
+	if _, err := NewMap(3, nil).SearchN("20", 2); err == nil {
+		t.Fail()
+	}
+}
+
 func TestConsistenthashConcurrent(t *testing.T) {
 	peerMap := NewMap(3, nil)
 	peerMap.Add("1", "2", "3")
@@ -0,0 +1,80 @@
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the gossip handler must be mounted on the path of every node
+var DefaultPath = "/_gossip/"
+
+const (
+	pingPath    = "ping"     // direct probe
+	pingReqPath = "ping-req" // ask the node to probe the target
+	syncPath    = "sync"     // full state exchange, used by join and push-pull
+)
+
+type message struct {
+	From    string   `json:"from"`
+	Target  string   `json:"target,omitempty"` // the member to be probed by ping-req
+	Updates []Member `json:"updates"`
+}
+
+// ServeHTTP handles the messages from the other members,
+// every response carries the updates piggybacked as well
+func (n *Node) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	var msg message
+	if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+		http.Error(resp, "bad request body", http.StatusBadRequest)
+		return
+	}
+	var reply message
+	switch strings.TrimPrefix(req.URL.Path, DefaultPath) {
+	case pingPath:
+		n.merge(msg.Updates)
+		reply = n.message("")
+	case pingReqPath:
+		n.merge(msg.Updates)
+		if err := n.ping(msg.Target); err != nil {
+			n.log("gossip-%s: [PING-REQ] %s -> %s failed: %v", n.self, msg.From, msg.Target, err)
+			http.Error(resp, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		reply = n.message("")
+	case syncPath:
+		n.merge(msg.Updates)
+		reply = message{From: n.self, Updates: n.Members()}
+	default:
+		http.Error(resp, "unexpected path: "+req.URL.Path, http.StatusNotFound)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(reply)
+}
+
+func (n *Node) send(addr string, path string, msg message, timeout time.Duration) (message, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return message{}, err
+	}
+	httpClient := http.Client{Timeout: timeout}
+	resp, err := httpClient.Post("http://"+addr+DefaultPath+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return message{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return message{}, errors.New(resp.Status)
+	}
+	var reply message
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	return reply, err
+}
@@ -0,0 +1,52 @@
+package gossip
+
+import "time"
+
+type State int
+
+// the order matters, the later state overrides the earlier one with the same incarnation
+const (
+	StateAlive State = iota
+	StateSuspect
+	StateDead // removed from the hash ring
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+// Member is also the membership delta piggybacked on the messages
+type Member struct {
+	Addr        string `json:"addr"`
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"incarnation"` // only increased by the member itself to refute a suspicion
+}
+
+// whether the update overrides the current state of the member
+func (m Member) overrides(cur Member) bool {
+	if m.Incarnation != cur.Incarnation {
+		return m.Incarnation > cur.Incarnation
+	}
+	return m.State > cur.State
+}
+
+// the member state kept by the node
+type member struct {
+	Member
+	since time.Time // time of the last state transition
+}
+
+// an update waiting to be piggybacked
+type broadcast struct {
+	member    Member
+	transmits int
+}
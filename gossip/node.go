@@ -0,0 +1,423 @@
+package gossip
+
+import (
+	"distributed_cache/common"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	ProbeInterval    time.Duration // one member is probed per interval
+	ProbeTimeout     time.Duration // timeout of the direct probe, the indirect probe waits twice as long
+	IndirectProbes   int           // members asked to probe the target when the direct probe fails
+	SuspectTimeout   time.Duration // the suspect member is declared dead after the timeout
+	PushPullInterval time.Duration // full state sync with a random member, <= 0 means disabled
+	MaxPiggyback     int           // max updates piggybacked on a message
+	RetransmitMult   int           // every update is piggybacked RetransmitMult * log10(n + 1) times
+}
+
+var DefaultConfig = Config{
+	ProbeInterval:    time.Second,
+	ProbeTimeout:     300 * time.Millisecond,
+	IndirectProbes:   3,
+	SuspectTimeout:   5 * time.Second,
+	PushPullInterval: 30 * time.Second,
+	MaxPiggyback:     16,
+	RetransmitMult:   4,
+}
+
+// Node is a member of the SWIM-style gossip cluster,
+// it probes a random member per interval and spreads the membership changes
+// on the probe messages
+type Node struct {
+	sync.Mutex
+	self      string
+	config    Config
+	members   map[string]*member // all known members, including the node itself and the dead ones
+	queue     []*broadcast       // the updates to be piggybacked
+	probeList []string           // probe order, shuffled every round
+	probeIdx  int
+	onChange  func(addrs []string)
+	notifyMu  sync.Mutex // keep the callbacks in order
+	stop      chan struct{}
+	once      sync.Once
+}
+
+type Option func(n *Node)
+
+func WithConfig(config Config) Option {
+	return func(n *Node) {
+		n.config = config
+	}
+}
+
+// f is called with the addrs of the alive and suspect members in order
+// whenever the members in the hash ring change
+func WithOnChange(f func(addrs []string)) Option {
+	return func(n *Node) {
+		n.onChange = f
+	}
+}
+
+// self: addr of the node, e.g. localhost:8001, the gossip handler must be mounted on it
+func NewNode(self string, opts ...Option) *Node {
+	n := &Node{
+		self:   self,
+		config: DefaultConfig,
+		members: map[string]*member{
+			self: {Member: Member{Addr: self, State: StateAlive}, since: time.Now()},
+		},
+		stop: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+func (n *Node) log(format string, v ...any) {
+	if common.DEBUG {
+		log.Printf(format, v...)
+	}
+}
+
+func (n *Node) Self() string {
+	return n.self
+}
+
+// Start probes the members periodically until Stop is called
+func (n *Node) Start() error {
+	c := n.config
+	if c.ProbeInterval <= 0 || c.ProbeTimeout <= 0 || c.IndirectProbes < 0 ||
+		c.SuspectTimeout <= 0 || c.MaxPiggyback <= 0 || c.RetransmitMult <= 0 {
+		return common.ErrPositiveParamNegative
+	}
+	n.notify()
+	go n.run()
+	return nil
+}
+
+func (n *Node) Stop() {
+	n.once.Do(func() {
+		close(n.stop)
+	})
+}
+
+func (n *Node) run() {
+	probe := time.NewTicker(n.config.ProbeInterval)
+	defer probe.Stop()
+	var pushPull <-chan time.Time
+	if n.config.PushPullInterval > 0 {
+		ticker := time.NewTicker(n.config.PushPullInterval)
+		defer ticker.Stop()
+		pushPull = ticker.C
+	}
+	for {
+		select {
+		case <-probe.C:
+			n.expireSuspects()
+			n.probe()
+		case <-pushPull:
+			n.pushPull()
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// Join exchanges the full state with the seeds,
+// it succeeds if any of the seeds responds
+func (n *Node) Join(seeds ...string) error {
+	err := common.ErrNoPeerRegistered
+	joined := 0
+	for _, seed := range seeds {
+		if seed == n.self {
+			continue
+		}
+		if e := n.sync(seed); e != nil {
+			n.log("gossip-%s: [ERROR] join %s error %v", n.self, seed, e)
+			err = e
+			continue
+		}
+		joined++
+	}
+	if joined == 0 {
+		return err
+	}
+	return nil
+}
+
+// push the full state to the member and merge its full state
+func (n *Node) sync(addr string) error {
+	resp, err := n.send(addr, syncPath, message{From: n.self, Updates: n.Members()}, n.config.ProbeTimeout)
+	if err != nil {
+		return err
+	}
+	n.merge(resp.Updates)
+	return nil
+}
+
+func (n *Node) pushPull() {
+	addrs := n.randomMembers(1)
+	if len(addrs) == 0 {
+		return
+	}
+	if err := n.sync(addrs[0]); err != nil {
+		n.log("gossip-%s: [ERROR] push-pull with %s error %v", n.self, addrs[0], err)
+	}
+}
+
+// probe the next member, ask others to probe it if the direct probe fails,
+// the member is suspect if all probes fail
+func (n *Node) probe() {
+	target, ok := n.nextTarget()
+	if !ok {
+		return
+	}
+	if err := n.ping(target); err == nil {
+		return
+	}
+	if n.indirectPing(target) {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	if m, ok := n.members[target]; ok && m.State == StateAlive {
+		n.apply(Member{Addr: target, State: StateSuspect, Incarnation: m.Incarnation})
+	}
+}
+
+func (n *Node) ping(target string) error {
+	resp, err := n.send(target, pingPath, n.message(""), n.config.ProbeTimeout)
+	if err != nil {
+		return err
+	}
+	n.merge(resp.Updates)
+	return nil
+}
+
+func (n *Node) indirectPing(target string) bool {
+	relays := n.randomMembers(n.config.IndirectProbes, target)
+	if len(relays) == 0 {
+		return false
+	}
+	acks := make(chan bool, len(relays))
+	for _, relay := range relays {
+		go func(relay string) {
+			resp, err := n.send(relay, pingReqPath, n.message(target), 2*n.config.ProbeTimeout)
+			if err == nil {
+				n.merge(resp.Updates)
+			}
+			acks <- err == nil
+		}(relay)
+	}
+	for range relays {
+		if <-acks {
+			return true
+		}
+	}
+	return false
+}
+
+// the round-robin probe target, the members are shuffled every round
+func (n *Node) nextTarget() (string, bool) {
+	n.Lock()
+	defer n.Unlock()
+	for {
+		if n.probeIdx >= len(n.probeList) {
+			n.probeList = n.probeList[:0]
+			for addr, m := range n.members {
+				if addr != n.self && m.State != StateDead {
+					n.probeList = append(n.probeList, addr)
+				}
+			}
+			if len(n.probeList) == 0 {
+				return "", false
+			}
+			rand.Shuffle(len(n.probeList), func(i, j int) {
+				n.probeList[i], n.probeList[j] = n.probeList[j], n.probeList[i]
+			})
+			n.probeIdx = 0
+		}
+		addr := n.probeList[n.probeIdx]
+		n.probeIdx++
+		// the member may be dead since the list was built
+		if m, ok := n.members[addr]; ok && m.State != StateDead {
+			return addr, true
+		}
+	}
+}
+
+// at most k random members which are not dead, except the node itself and the excluded ones
+func (n *Node) randomMembers(k int, excluded ...string) []string {
+	n.Lock()
+	defer n.Unlock()
+	var addrs []string
+	for addr, m := range n.members {
+		if addr == n.self || m.State == StateDead || contains(excluded, addr) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	if len(addrs) > k {
+		addrs = addrs[:k]
+	}
+	return addrs
+}
+
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// declare the suspect members dead after the suspect timeout
+func (n *Node) expireSuspects() {
+	n.Lock()
+	changed := false
+	for _, m := range n.members {
+		if m.State == StateSuspect && time.Since(m.since) >= n.config.SuspectTimeout {
+			changed = n.apply(Member{Addr: m.Addr, State: StateDead, Incarnation: m.Incarnation}) || changed
+		}
+	}
+	n.Unlock()
+	if changed {
+		n.notify()
+	}
+}
+
+func (n *Node) merge(updates []Member) {
+	n.Lock()
+	changed := false
+	for _, update := range updates {
+		changed = n.apply(update) || changed
+	}
+	n.Unlock()
+	if changed {
+		n.notify()
+	}
+}
+
+// apply the update and queue it for the piggyback if it is new,
+// returns whether the members in the hash ring change, must hold the lock
+func (n *Node) apply(update Member) bool {
+	if update.Addr == n.self {
+		self := n.members[n.self]
+		// refute the suspicion with a larger incarnation
+		if update.State != StateAlive && update.Incarnation >= self.Incarnation {
+			self.Incarnation = update.Incarnation + 1
+			n.log("gossip-%s: refute %s with incarnation %d", n.self, update.State, self.Incarnation)
+			n.enqueue(self.Member)
+		}
+		return false
+	}
+	cur, ok := n.members[update.Addr]
+	if !ok {
+		n.members[update.Addr] = &member{Member: update, since: time.Now()}
+		n.log("gossip-%s: member[%s] joined as %s", n.self, update.Addr, update.State)
+		n.enqueue(update)
+		return update.State != StateDead
+	}
+	if !update.overrides(cur.Member) {
+		return false
+	}
+	inRing := cur.State != StateDead
+	if cur.State != update.State {
+		n.log("gossip-%s: member[%s] %s -> %s", n.self, update.Addr, cur.State, update.State)
+		cur.since = time.Now()
+	}
+	cur.Member = update
+	n.enqueue(update)
+	return inRing != (update.State != StateDead)
+}
+
+// the newer update of the member replaces the queued one, must hold the lock
+func (n *Node) enqueue(update Member) {
+	for _, b := range n.queue {
+		if b.member.Addr == update.Addr {
+			b.member = update
+			b.transmits = 0
+			return
+		}
+	}
+	n.queue = append(n.queue, &broadcast{member: update})
+}
+
+// the message with the least transmitted updates piggybacked
+func (n *Node) message(target string) message {
+	n.Lock()
+	defer n.Unlock()
+	sort.SliceStable(n.queue, func(i, j int) bool {
+		return n.queue[i].transmits < n.queue[j].transmits
+	})
+	limit := n.retransmitLimit()
+	msg := message{From: n.self, Target: target}
+	for i := 0; i < len(n.queue) && i < n.config.MaxPiggyback; i++ {
+		msg.Updates = append(msg.Updates, n.queue[i].member)
+		n.queue[i].transmits++
+	}
+	queue := n.queue[:0]
+	for _, b := range n.queue {
+		if b.transmits < limit {
+			queue = append(queue, b)
+		}
+	}
+	n.queue = queue
+	return msg
+}
+
+// must hold the lock
+func (n *Node) retransmitLimit() int {
+	alive := 0
+	for _, m := range n.members {
+		if m.State != StateDead {
+			alive++
+		}
+	}
+	return n.config.RetransmitMult * int(math.Ceil(math.Log10(float64(alive+1))))
+}
+
+func (n *Node) notify() {
+	if n.onChange == nil {
+		return
+	}
+	n.notifyMu.Lock()
+	defer n.notifyMu.Unlock()
+	n.onChange(n.Peers())
+}
+
+// Members returns the states of all known members in order
+func (n *Node) Members() []Member {
+	n.Lock()
+	defer n.Unlock()
+	members := make([]Member, 0, len(n.members))
+	for _, m := range n.members {
+		members = append(members, m.Member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Addr < members[j].Addr
+	})
+	return members
+}
+
+// Peers returns the addrs of the alive and suspect members in order,
+// which are the members in the hash ring
+func (n *Node) Peers() []string {
+	var addrs []string
+	for _, m := range n.Members() {
+		if m.State != StateDead {
+			addrs = append(addrs, m.Addr)
+		}
+	}
+	return addrs
+}
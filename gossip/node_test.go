@@ -0,0 +1,185 @@
+package gossip
+
+import (
+	"distributed_cache/consistenthash"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	ProbeInterval:    10 * time.Millisecond,
+	ProbeTimeout:     50 * time.Millisecond,
+	IndirectProbes:   2,
+	SuspectTimeout:   100 * time.Millisecond,
+	PushPullInterval: 50 * time.Millisecond,
+	MaxPiggyback:     8,
+	RetransmitMult:   4,
+}
+
+type testNode struct {
+	*Node
+	server *httptest.Server
+
+	mu    sync.Mutex
+	peers []string // the last peers passed to the callback
+}
+
+func (n *testNode) lastPeers() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.peers
+}
+
+func newTestNode(t *testing.T) *testNode {
+	server := httptest.NewUnstartedServer(nil)
+	node := &testNode{server: server}
+	node.Node = NewNode(
+		server.Listener.Addr().String(),
+		WithConfig(testConfig),
+		WithOnChange(func(addrs []string) {
+			node.mu.Lock()
+			node.peers = addrs
+			node.mu.Unlock()
+		}),
+	)
+	server.Config.Handler = node.Node
+	server.Start()
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func (n *testNode) close() {
+	n.Stop()
+	n.server.Close()
+}
+
+func waitPeers(nodes []*testNode, want []string) bool {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		converged := true
+		for _, node := range nodes {
+			if !reflect.DeepEqual(node.lastPeers(), want) {
+				converged = false
+				break
+			}
+		}
+		if converged {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestOverrides(t *testing.T) {
+	cur := Member{Addr: "a", State: StateSuspect, Incarnation: 1}
+	cases := []struct {
+		update Member
+		want   bool
+	}{
+		{Member{Addr: "a", State: StateAlive, Incarnation: 1}, false},
+		{Member{Addr: "a", State: StateAlive, Incarnation: 2}, true},
+		{Member{Addr: "a", State: StateSuspect, Incarnation: 1}, false},
+		{Member{Addr: "a", State: StateDead, Incarnation: 1}, true},
+		{Member{Addr: "a", State: StateDead, Incarnation: 0}, false},
+	}
+	for _, c := range cases {
+		if c.update.overrides(cur) != c.want {
+			t.Errorf("%+v overrides %+v should be %v", c.update, cur, c.want)
+		}
+	}
+}
+
+func TestRefute(t *testing.T) {
+	node := NewNode("a")
+	node.merge([]Member{{Addr: "a", State: StateSuspect, Incarnation: 0}})
+	members := node.Members()
+	if members[0].State != StateAlive || members[0].Incarnation != 1 {
+		t.Fatalf("the suspicion must be refuted, got %+v", members[0])
+	}
+	msg := node.message("")
+	if len(msg.Updates) != 1 || msg.Updates[0].Incarnation != 1 {
+		t.Fatal("the refutation must be piggybacked")
+	}
+	// the stale suspicion is ignored
+	node.merge([]Member{{Addr: "a", State: StateSuspect, Incarnation: 0}})
+	if node.Members()[0].Incarnation != 1 {
+		t.Fail()
+	}
+}
+
+func TestGossipConverge(t *testing.T) {
+	var nodes []*testNode
+	var addrs []string
+	for i := 0; i < 8; i++ {
+		node := newTestNode(t)
+		defer node.close()
+		nodes = append(nodes, node)
+		addrs = append(addrs, node.Self())
+	}
+	// every node only knows the seed
+	for _, node := range nodes[1:] {
+		if err := node.Join(nodes[0].Self()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := consistenthash.NewMap(3, nil)
+	want.Add(addrs...)
+	sorted := append([]string(nil), addrs...)
+	sort.Strings(sorted)
+	if !waitPeers(nodes, sorted) {
+		for _, node := range nodes {
+			t.Logf("%s: %v", node.Self(), node.lastPeers())
+		}
+		t.Fatal("the members must converge")
+	}
+	// the same peers build the same hash ring
+	for _, node := range nodes {
+		ring := consistenthash.NewMap(3, nil)
+		ring.Add(node.lastPeers()...)
+		for _, key := range []string{"1", "2", "Tom", "Jack"} {
+			got, _ := ring.Search(key)
+			expected, _ := want.Search(key)
+			if got != expected {
+				t.Fatalf("key %s is owned by %s on %s, but should be %s", key, got, node.Self(), expected)
+			}
+		}
+	}
+
+	// the failed node is removed from the ring of every node
+	failed := nodes[len(nodes)-1]
+	failed.close()
+	if !waitPeers(nodes[:len(nodes)-1], without(sorted, failed.Self())) {
+		t.Fatal("the failed node must be removed")
+	}
+}
+
+func TestJoinFailed(t *testing.T) {
+	node := NewNode("localhost:1", WithConfig(testConfig))
+	if err := node.Join("localhost:1"); err == nil {
+		t.Fatal("join without any seed must fail")
+	}
+	server := httptest.NewServer(nil)
+	addr := strings.TrimPrefix(server.URL, "http://")
+	server.Close()
+	if err := node.Join(addr); err == nil {
+		t.Fatal("join the unreachable seed must fail")
+	}
+}
+
+func without(addrs []string, addr string) []string {
+	var res []string
+	for _, a := range addrs {
+		if a != addr {
+			res = append(res, a)
+		}
+	}
+	return res
+}
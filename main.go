@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"distributed_cache/cache"
+	"distributed_cache/client"
 	"distributed_cache/common"
+	"distributed_cache/gossip"
 	"distributed_cache/master"
+	"distributed_cache/metrics"
+	"distributed_cache/raft"
 	"distributed_cache/server"
 	"distributed_cache/service"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,9 +26,9 @@ import (
 var db = make(map[string]string)
 var numbers = 100
 
-func NewCacheService(addr string, serviceName string) {
+func NewCacheService(addr string, serviceName string, masterAddr string, peers string, seeds string, enableGossip bool, policy string) {
 	fmt.Printf("cache service [%s] is running at [%s]\n", serviceName, addr)
-	service.NewService(
+	svc := service.NewService(
 		serviceName,
 		service.GetterFunc(func(key string) ([]byte, error) {
 			// simulate the long time waiting
@@ -41,9 +49,144 @@ func NewCacheService(addr string, serviceName string) {
 		}),
 		int64(common.CacheCapacity),
 		2,
+		time.Minute,
+		service.WithPolicy(policy),
 	)
 	server := server.NewHTTPPool(addr)
-	log.Fatal(http.ListenAndServe(addr, server))
+	if peers != "" {
+		// peer-to-peer mode, the node forwards the miss to the owner
+		if err := server.Set(strings.Split(peers, ",")...); err != nil {
+			log.Fatal(err)
+		}
+		svc.RegisterPeers(server)
+	}
+	var handler http.Handler = server
+	if enableGossip {
+		// the hash ring follows the gossip membership
+		node := gossip.NewNode(addr, gossip.WithOnChange(func(addrs []string) {
+			if err := server.Set(addrs...); err != nil {
+				log.Printf("cache service [%s]: [ERROR] update peers %v: %v", serviceName, addrs, err)
+			}
+		}))
+		if peers == "" {
+			svc.RegisterPeers(server)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(gossip.DefaultPath, node)
+		mux.Handle("/", server)
+		handler = mux
+		if err := node.Start(); err != nil {
+			log.Fatal(err)
+		}
+		if seeds != "" {
+			// the seeds may not be running yet, keep trying in background
+			go func() {
+				for node.Join(strings.Split(seeds, ",")...) != nil {
+					time.Sleep(common.RegisterRetryInterval)
+				}
+			}()
+		}
+	}
+	if masterAddr != "" {
+		// the master may not be running yet, keep trying in background
+		go client.NewMasterClient("http://"+masterAddr+master.DefaultClusterPath).
+			RegisterWithRetry(addr, common.RegisterRetryInterval, nil)
+	}
+	log.Fatal(http.ListenAndServe(addr, handler))
+}
+
+func NewMasterService(addr string, peers string, masters string, raftState string, consistency master.Consistency) {
+	m := master.NewMaster(
+		3,
+		nil,
+		master.WithReplicationFactor(consistency.N),
+		master.WithRebalance(master.RebalanceConfig{Rate: 1000}),
+	)
+	// the consistency comes from the flags, refuse to start with the invalid one
+	if err := m.SetConsistency("test", consistency); err != nil {
+		log.Fatalf("invalid consistency %+v: %v", consistency, err)
+	}
+	if masters != "" {
+		// the membership is replicated among the masters, only the leader accepts the writes
+		config := raft.DefaultConfig
+		config.StatePath = raftState
+		node := raft.NewNode(addr, strings.Split(masters, ","), m, config)
+		m.SetReplicator(node)
+		http.Handle(raft.DefaultPath, node)
+		if err := node.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if peers != "" {
+		// the peers are registered by whichever master becomes the leader
+		go func() {
+			for {
+				if m.IsLeader() {
+					err := m.Register("http://", server.DefaultServiceName, strings.Split(peers, ",")...)
+					if err == nil || err == common.ErrPeerRegistered {
+						return
+					}
+				}
+				time.Sleep(common.RegisterRetryInterval)
+			}
+		}()
+	}
+	m.StartHeartbeat(master.DefaultHeartbeatConfig)
+	cluster := master.NewClusterHandler(m, "http://", server.DefaultServiceName)
+	http.Handle(master.DefaultClusterPath, cluster)
+	http.Handle(master.DefaultClusterPath+"/", cluster)
+	http.Handle(master.DefaultClusterHealthPath, cluster)
+	http.Handle(master.DefaultClusterRebalancePath, cluster)
+	http.Handle(common.MetricsPath, m.Metrics())
+	http.Handle("/api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serviceName := r.URL.Query().Get("name")
+		key := r.URL.Query().Get("key")
+		// the budget of the request bounds the routed requests to the cache peers
+		ctx, cancel := context.WithTimeout(r.Context(), common.RequestTimeout)
+		defer cancel()
+		switch r.Method {
+		case http.MethodGet:
+			value, err := m.GetContext(ctx, serviceName, key)
+			if err == nil {
+				w.Write(value)
+				return
+			}
+			if errors.Is(err, common.ErrKeyNotInDB) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case http.MethodPut:
+			value, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = m.PutContext(ctx, serviceName, key, value)
+			if err == nil {
+				return
+			}
+			if err == common.ErrNotLeader {
+				master.RedirectToLeader(w, r, m)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case http.MethodDelete:
+			err := m.InvalidateContext(ctx, serviceName, key)
+			if err == nil {
+				return
+			}
+			if err == common.ErrNotLeader {
+				master.RedirectToLeader(w, r, m)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			http.Error(w, "method not allowed: "+r.Method, http.StatusMethodNotAllowed)
+		}
+	}))
+	fmt.Printf("api service [master] is running at [%s]\n", addr)
+	log.Fatal(http.ListenAndServe(addr, metrics.InstrumentHandler(m.Metrics(), http.DefaultServeMux)))
 }
 
 func genDataInDB() {
@@ -54,41 +197,35 @@ func genDataInDB() {
 
 func main() {
 	var (
-		port    string
-		isCache bool
+		port       string
+		isCache    bool
+		masterAddr string
+		peers      string
+		masters    string
+		raftState  string
+		seeds      string
+		useGossip  bool
+		policy     string
+		quorum     master.Consistency
 	)
 	flag.StringVar(&port, "port", "8001", "service port")
 	flag.BoolVar(&isCache, "cache", true, "cache or master?")
+	flag.StringVar(&masterAddr, "master", "", "cache: master addr to register on, e.g. localhost:9999")
+	flag.StringVar(&peers, "peers", "", "master: cache addrs registered on startup; cache: all cache addrs of the peer-to-peer mode, separated by comma")
+	flag.BoolVar(&useGossip, "gossip", false, "cache: discover the other cache nodes by gossip, the peer-to-peer mode")
+	flag.StringVar(&seeds, "seeds", "", "cache: seed addrs to join the gossip cluster, separated by comma")
+	flag.StringVar(&policy, "policy", service.DefaultPolicy, "cache: eviction policy, one of "+strings.Join(cache.Policies(), ", "))
+	flag.StringVar(&masters, "masters", "", "master: addrs of all the master instances including itself, separated by comma")
+	flag.StringVar(&raftState, "raft-state", "", "master: file of the raft term, vote and log, the state is kept in memory only if it is empty")
+	flag.IntVar(&quorum.N, "replication", 1, "master: replication factor of the keys")
+	flag.IntVar(&quorum.R, "r", 1, "master: responses required by read")
+	flag.IntVar(&quorum.W, "w", 1, "master: acknowledgements required by write")
 	flag.Parse()
 	genDataInDB()
 
-	port2addr := map[string]string{
-		"8001": "localhost:8001",
-		"8002": "localhost:8002",
-		"8003": "localhost:8003",
-		"8004": "localhost:8004",
-	}
 	if isCache {
-		NewCacheService(port2addr[port], "test")
+		NewCacheService("localhost:"+port, "test", masterAddr, peers, seeds, useGossip, policy)
 	} else {
-		addr := "localhost:" + port
-		master := master.NewMaster(3, nil)
-		var addrs []string
-		for _, addr := range port2addr {
-			addrs = append(addrs, addr)
-		}
-		master.Register("http://", "/_Cache/", addrs...)
-		http.Handle("/api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			serviceName := r.URL.Query().Get("name")
-			key := r.URL.Query().Get("key")
-			value, err := master.Get(serviceName, key)
-			if err == nil {
-				w.Write(value)
-				return
-			}
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}))
-		fmt.Printf("api service [master] is running at [%s]\n", addr)
-		http.ListenAndServe(addr, nil)
+		NewMasterService("localhost:"+port, peers, masters, raftState, quorum)
 	}
 }
@@ -1,26 +1,65 @@
 package master
 
 import (
+	"context"
 	"distributed_cache/client"
 	"distributed_cache/common"
 	"distributed_cache/consistenthash"
+	"distributed_cache/metrics"
 	"log"
+	"sort"
 	"sync"
+	"time"
 )
 
 type Master struct {
 	sync.RWMutex
-	register *consistenthash.Map
-	peers    map[string]*client.Client
+	register    *consistenthash.Map
+	peers       map[string]*client.Client // all registered peers, including the dead ones
+	status      map[string]*PeerStatus
+	heartbeat   *heartbeat
+	factor      int // replication factor, every key is stored on factor peers
+	consistency map[string]Consistency
+	version     uint64 // the last write version
+
+	replicator Replicator // nil means the master runs alone
+
+	rebalanceConfig *RebalanceConfig // nil means the rebalance is disabled
+	rebalanceStatus RebalanceStatus
+	rebalanceMu     sync.Mutex
+
+	metrics *metrics.Registry
+	routes  *routeMetrics
+}
+
+type Option func(m *Master)
+
+// every key is written to the n successive peers on the hash ring
+func WithReplicationFactor(n int) Option {
+	return func(m *Master) {
+		if n > 0 {
+			m.factor = n
+		}
+	}
 }
 
 // replias: virtual peer num
 // hash: hash function
-func NewMaster(replias int, hash consistenthash.HashFunc) *Master {
-	return &Master{
-		register: consistenthash.NewMap(replias, hash),
-		peers:    make(map[string]*client.Client),
+func NewMaster(replias int, hash consistenthash.HashFunc, opts ...Option) *Master {
+	m := &Master{
+		register:    consistenthash.NewMap(replias, hash),
+		peers:       make(map[string]*client.Client),
+		status:      make(map[string]*PeerStatus),
+		factor:      1,
+		consistency: make(map[string]Consistency),
+		metrics:     metrics.NewRegistry(),
+		routes:      newRouteMetrics(),
+	}
+	m.metrics.Register(m.routes.requests, m.routes.duration, metrics.CollectorFunc(m.collectPeers))
+	for _, opt := range opts {
+		opt(m)
 	}
+	return m
 }
 
 func (m *Master) log(format string, v ...any) {
@@ -29,54 +68,187 @@ func (m *Master) log(format string, v ...any) {
 	}
 }
 
-func (m *Master) direct(key string) (*client.Client, error) {
-	var (
-		addr string
-		err  error
-	)
-	addr, err = m.register.Search(key)
+// the n replicas of the key in ring order, the alive peers come before the suspect ones
+func (m *Master) direct(key string, n int) ([]*client.Client, error) {
+	m.RLock()
+	defer m.RUnlock()
+	addrs, err := m.register.SearchN(key, n)
 	if err != nil {
 		return nil, err
 	}
-	return m.peers[addr], nil
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return m.status[addrs[i]].State < m.status[addrs[j]].State
+	})
+	peers := make([]*client.Client, len(addrs))
+	for i, addr := range addrs {
+		peers[i] = m.peers[addr]
+	}
+	return peers, nil
 }
 
 func (m *Master) Register(prefix string, suffix string, addrs ...string) error {
+	if m.replicator != nil {
+		return m.propose(command{Op: opRegister, Prefix: prefix, Suffix: suffix, Addrs: addrs})
+	}
+	return m.addPeers(prefix, suffix, addrs...)
+}
+
+func (m *Master) addPeers(prefix string, suffix string, addrs ...string) error {
 	var err error
 	m.Lock()
 	defer m.Unlock()
+	for _, addr := range addrs {
+		// the dead peer is not in the hash ring
+		if _, ok := m.peers[addr]; ok {
+			return common.ErrPeerRegistered
+		}
+	}
+	old := m.register.Clone()
 	err = m.register.Add(addrs...)
 	if err != nil {
 		return err
 	}
 	for _, addr := range addrs {
 		m.peers[addr] = client.NewClient(prefix + addr + suffix)
+		m.status[addr] = &PeerStatus{Addr: addr, State: PeerAlive, Since: time.Now()}
 	}
+	m.rebalance(old, nil)
 	return nil
 }
 
 func (m *Master) Delete(addrs ...string) error {
+	if m.replicator != nil {
+		return m.propose(command{Op: opDelete, Addrs: addrs})
+	}
+	return m.removePeers(addrs...)
+}
+
+func (m *Master) removePeers(addrs ...string) error {
 	var err error
 	m.Lock()
 	defer m.Unlock()
-	err = m.register.Delete(addrs...)
+	var inRing []string
+	for _, addr := range addrs {
+		status, ok := m.status[addr]
+		if !ok {
+			return common.ErrPeerNotRegistered
+		}
+		if status.State != PeerDead {
+			inRing = append(inRing, addr)
+		}
+	}
+	old := m.register.Clone()
+	err = m.register.Delete(inRing...)
 	if err != nil {
 		return err
 	}
+	// the leaving peers stream their entries before they are gone
+	oldPeers := make(map[string]*client.Client, len(addrs))
 	for _, addr := range addrs {
+		oldPeers[addr] = m.peers[addr]
 		delete(m.peers, addr)
+		delete(m.status, addr)
 	}
+	m.rebalance(old, oldPeers)
 	return nil
 }
 
-func (m *Master) Get(serviceName string, key string) ([]byte, error) {
+// registered peer addrs in order
+func (m *Master) Peers() []string {
 	m.RLock()
 	defer m.RUnlock()
+	addrs := make([]string, 0, len(m.peers))
+	for addr := range m.peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (m *Master) Get(serviceName string, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), common.RequestTimeout)
+	defer cancel()
+	return m.GetContext(ctx, serviceName, key)
+}
+
+// GetContext bounds the reads of the replicas by the deadline of the context,
+// the remaining budget is carried to the cache peers
+func (m *Master) GetContext(ctx context.Context, serviceName string, key string) ([]byte, error) {
 	m.log("Master: [GET] service[%s] key[%s]", serviceName, key)
-	peer, err := m.direct(key)
+	c := m.consistencyOf(serviceName)
+	peers, err := m.direct(key, c.N)
 	if err != nil {
 		return nil, err
 	}
-	m.log("direct to %s", peer.ServerAddr())
-	return peer.Get(serviceName, key)
+	if c.R > 1 {
+		return m.quorumGet(ctx, peers, min(c.R, len(peers)), serviceName, key)
+	}
+	// read from the first replica which responds
+	var value []byte
+	for _, peer := range peers {
+		m.log("direct to %s", peer.ServerAddr())
+		err = m.route(peer, "get", func() error {
+			value, err = peer.GetContext(ctx, serviceName, key)
+			return err
+		})
+		if err == nil {
+			return value, nil
+		}
+	}
+	return nil, err
+}
+
+// Put
+// write the value with a new version through the cache peers which own the key,
+// return once W of them acknowledged, only the leader accepts the write
+func (m *Master) Put(serviceName string, key string, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), common.RequestTimeout)
+	defer cancel()
+	return m.PutContext(ctx, serviceName, key, value)
+}
+
+func (m *Master) PutContext(ctx context.Context, serviceName string, key string, value []byte) error {
+	m.log("Master: [PUT] service[%s] key[%s]", serviceName, key)
+	if !m.IsLeader() {
+		return common.ErrNotLeader
+	}
+	c := m.consistencyOf(serviceName)
+	peers, err := m.direct(key, c.N)
+	if err != nil {
+		return err
+	}
+	version := m.nextVersion()
+	return quorum(peers, min(c.W, len(peers)), func(peer *client.Client) error {
+		m.log("direct to %s", peer.ServerAddr())
+		return m.route(peer, "put", func() error {
+			return peer.PutVersionedContext(ctx, serviceName, key, value, version)
+		})
+	})
+}
+
+// Invalidate
+// remove the key from the cache peers which own it, return once W of them acknowledged,
+// only the leader accepts the invalidation
+func (m *Master) Invalidate(serviceName string, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), common.RequestTimeout)
+	defer cancel()
+	return m.InvalidateContext(ctx, serviceName, key)
+}
+
+func (m *Master) InvalidateContext(ctx context.Context, serviceName string, key string) error {
+	m.log("Master: [DELETE] service[%s] key[%s]", serviceName, key)
+	if !m.IsLeader() {
+		return common.ErrNotLeader
+	}
+	c := m.consistencyOf(serviceName)
+	peers, err := m.direct(key, c.N)
+	if err != nil {
+		return err
+	}
+	return quorum(peers, min(c.W, len(peers)), func(peer *client.Client) error {
+		m.log("direct to %s", peer.ServerAddr())
+		return m.route(peer, "delete", func() error {
+			return peer.DeleteContext(ctx, serviceName, key)
+		})
+	})
 }
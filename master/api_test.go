@@ -0,0 +1,350 @@
+package master
+
+import (
+	"distributed_cache/client"
+	"distributed_cache/common"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNode simulates a cache node with its own storage
+type fakeNode struct {
+	sync.Mutex
+	data     map[string][]byte
+	versions map[string]uint64
+	migrates []client.MigrateRequest
+	batches  int
+	down     bool
+	hang     bool // the migrate request never finishes until it is canceled
+	*httptest.Server
+}
+
+func newFakeNode() *fakeNode {
+	n := &fakeNode{
+		data:     make(map[string][]byte),
+		versions: make(map[string]uint64),
+	}
+	n.Server = httptest.NewServer(n)
+	return n
+}
+
+func (n *fakeNode) addr() string {
+	return strings.TrimPrefix(n.URL, "http://")
+}
+
+func (n *fakeNode) get(key string) ([]byte, bool) {
+	n.Lock()
+	defer n.Unlock()
+	value, ok := n.data[key]
+	return value, ok
+}
+
+func (n *fakeNode) set(key string, value []byte, version uint64) {
+	n.Lock()
+	defer n.Unlock()
+	n.data[key] = value
+	n.versions[key] = version
+}
+
+func (n *fakeNode) setDown(down bool) {
+	n.Lock()
+	defer n.Unlock()
+	n.down = down
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.Lock()
+	defer n.Unlock()
+	if n.down {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	if r.URL.Path == common.HealthPath {
+		return
+	}
+	if r.URL.Path == common.MigratePath {
+		var migrate client.MigrateRequest
+		json.NewDecoder(r.Body).Decode(&migrate)
+		n.migrates = append(n.migrates, migrate)
+		if n.hang {
+			n.Unlock()
+			<-r.Context().Done()
+			n.Lock()
+			return
+		}
+		json.NewEncoder(w).Encode(client.MigrateResult{Moved: 1})
+		return
+	}
+	if r.URL.Path == common.BatchPath {
+		var multi client.GetMultiRequest
+		json.NewDecoder(r.Body).Decode(&multi)
+		n.batches++
+		result := client.GetMultiResult{Values: make(map[string][]byte)}
+		for _, key := range multi.Keys {
+			if value, ok := n.data[key]; ok {
+				result.Values[key] = value
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	// /_Cache/<service>/<key>
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/_Cache/"), "/", 2)
+	key := parts[1]
+	switch r.Method {
+	case http.MethodGet:
+		value, ok := n.data[key]
+		if !ok {
+			w.Header().Set(common.NotFoundHeader, "1")
+			http.Error(w, "key not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set(common.VersionHeader, strconv.FormatUint(n.versions[key], 10))
+		w.Write(value)
+	case http.MethodPut:
+		version, _ := strconv.ParseUint(r.Header.Get(common.VersionHeader), 10, 64)
+		if version < n.versions[key] {
+			return
+		}
+		n.data[key], _ = io.ReadAll(r.Body)
+		n.versions[key] = version
+	case http.MethodDelete:
+		delete(n.data, key)
+	}
+}
+
+func newTestCluster(t *testing.T, size int, opts ...Option) (*Master, []*fakeNode) {
+	m := NewMaster(3, nil, opts...)
+	nodes := make([]*fakeNode, size)
+	for i := range nodes {
+		nodes[i] = newFakeNode()
+		t.Cleanup(nodes[i].Close)
+		if err := m.Register("http://", "/_Cache/", nodes[i].addr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m, nodes
+}
+
+func TestMasterReplication(t *testing.T) {
+	m, nodes := newTestCluster(t, 4, WithReplicationFactor(3))
+	if err := m.Put("test", "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	replicas := 0
+	for _, node := range nodes {
+		if value, ok := node.get("key"); ok && string(value) == "value" {
+			replicas++
+		}
+	}
+	if replicas != 3 {
+		t.Fatalf("the key must be written to 3 peers, but get %d", replicas)
+	}
+
+	// read from the next replica if the first one failed
+	owners, _ := m.register.SearchN("key", 3)
+	for _, node := range nodes {
+		if node.addr() == owners[0] {
+			node.setDown(true)
+		}
+	}
+	if value, err := m.Get("test", "key"); err != nil || string(value) != "value" {
+		t.Fatal(value, err)
+	}
+
+	if err := m.Invalidate("test", "key"); err == nil {
+		t.Fatal("invalidate must fail if a replica is down")
+	}
+	for _, node := range nodes {
+		if _, ok := node.get("key"); ok && node.addr() != owners[0] {
+			t.Fail()
+		}
+	}
+}
+
+func TestMasterQuorumRead(t *testing.T) {
+	m, nodes := newTestCluster(t, 3, WithConsistency("test", Consistency{N: 3, R: 3, W: 3}))
+	nodes[0].set("key", []byte("old"), 1)
+	nodes[1].set("key", []byte("new"), 3)
+	nodes[2].set("key", []byte("mid"), 2)
+	if value, err := m.Get("test", "key"); err != nil || string(value) != "new" {
+		t.Fatalf("must read the freshest value, but get %s, %v", value, err)
+	}
+	// the replicas agree on the key not in db
+	if _, err := m.Get("test", "none"); err != common.ErrKeyNotInDB {
+		t.Fatal(err)
+	}
+	nodes[0].setDown(true)
+	if _, err := m.Get("test", "key"); err != common.ErrQuorumNotReached {
+		t.Fail()
+	}
+}
+
+func TestMasterQuorumWrite(t *testing.T) {
+	m, nodes := newTestCluster(t, 3)
+	if err := m.SetConsistency("test", Consistency{N: 3, R: 2, W: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetConsistency("test", Consistency{N: 3, R: 4, W: 2}); err == nil {
+		t.Fail()
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the invalid consistency option must panic")
+			}
+		}()
+		NewMaster(3, nil, WithConsistency("test", Consistency{N: 3, R: 0, W: 2}))
+	}()
+
+	nodes[0].setDown(true)
+	if err := m.Put("test", "key", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := m.Get("test", "key"); err != nil || string(value) != "v1" {
+		t.Fatal(value, err)
+	}
+	// the later write always has the newer version
+	if err := m.Put("test", "key", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := m.Get("test", "key"); string(value) != "v2" {
+		t.Fail()
+	}
+
+	nodes[1].setDown(true)
+	if err := m.Put("test", "key", []byte("v3")); err != common.ErrQuorumNotReached {
+		t.Fail()
+	}
+	if _, err := m.Get("test", "key"); err != common.ErrQuorumNotReached {
+		t.Fail()
+	}
+}
+
+func TestMasterRouteMetrics(t *testing.T) {
+	m, nodes := newTestCluster(t, 1)
+	peer := "http://" + nodes[0].addr() + "/_Cache/"
+	m.Put("test", "key", []byte("v"))
+	m.Get("test", "key")
+	m.Get("test", "none")
+	m.Invalidate("test", "key")
+	var buf strings.Builder
+	m.Metrics().WriteTo(&buf)
+	for _, line := range []string{
+		`master_routed_requests_total{peer="` + peer + `",op="get",result="error"} 1`,
+		`master_routed_requests_total{peer="` + peer + `",op="get",result="ok"} 1`,
+		`master_routed_requests_total{peer="` + peer + `",op="put",result="ok"} 1`,
+		`master_routed_requests_total{peer="` + peer + `",op="delete",result="ok"} 1`,
+		`master_routed_request_duration_seconds_count{peer="` + peer + `",op="get"} 2`,
+		`master_peers{state="alive"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("%q not in the metrics:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestMasterGetMulti(t *testing.T) {
+	m, nodes := newTestCluster(t, 3)
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		if err := m.Put("test", keys[i], []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values, err := m.GetMulti("test", append(keys, "none"))
+	if err != nil || len(values) != len(keys) {
+		t.Fatal(err, values)
+	}
+	for i, key := range keys {
+		if string(values[key]) != strconv.Itoa(i) {
+			t.Fatalf("%s: %s", key, values[key])
+		}
+	}
+	// one round trip per owner
+	for _, node := range nodes {
+		node.Lock()
+		batches := node.batches
+		node.Unlock()
+		if batches > 1 {
+			t.Fatalf("%s is requested %d times", node.addr(), batches)
+		}
+	}
+
+	// the values of the other owners are returned with the error
+	nodes[0].setDown(true)
+	down := 0
+	for _, key := range keys {
+		if owner, _ := m.register.Search(key); owner == nodes[0].addr() {
+			down++
+		}
+	}
+	values, err = m.GetMulti("test", keys)
+	if (err != nil) != (down > 0) || len(values) != len(keys)-down {
+		t.Fatal(err, len(values), down)
+	}
+}
+
+func TestMasterGetMultiQuorum(t *testing.T) {
+	m, nodes := newTestCluster(t, 3, WithConsistency("test", Consistency{N: 3, R: 3, W: 3}))
+	// the first replica of every key has the stale value
+	nodes[0].set("key", []byte("old"), 1)
+	nodes[1].set("key", []byte("new"), 3)
+	nodes[2].set("key", []byte("mid"), 2)
+	values, err := m.GetMulti("test", []string{"key", "none"})
+	if err != nil || len(values) != 1 || string(values["key"]) != "new" {
+		t.Fatal(err, values)
+	}
+	// the quorum is not reached
+	nodes[0].setDown(true)
+	if values, err := m.GetMulti("test", []string{"key"}); err != common.ErrQuorumNotReached || len(values) != 0 {
+		t.Fatal(err, values)
+	}
+}
+
+func TestMasterGetMultiFailover(t *testing.T) {
+	m, nodes := newTestCluster(t, 3, WithReplicationFactor(3))
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		if err := m.Put("test", keys[i], []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the suspect peer is asked last
+	m.Lock()
+	m.status[nodes[1].addr()].State = PeerSuspect
+	m.Unlock()
+	// the keys of the down peer are read from the next replicas
+	nodes[0].setDown(true)
+	values, err := m.GetMulti("test", keys)
+	if err != nil || len(values) != len(keys) {
+		t.Fatal(err, len(values))
+	}
+	for i, key := range keys {
+		if string(values[key]) != strconv.Itoa(i) {
+			t.Fatalf("%s: %s", key, values[key])
+		}
+	}
+	nodes[1].Lock()
+	batches := nodes[1].batches
+	nodes[1].Unlock()
+	if batches != 0 {
+		t.Fatalf("the suspect peer is requested %d times", batches)
+	}
+
+	// no replica serves the keys
+	for _, node := range nodes {
+		node.setDown(true)
+	}
+	if values, err := m.GetMulti("test", keys); err == nil || len(values) != 0 {
+		t.Fatal(err, len(values))
+	}
+}
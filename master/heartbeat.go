@@ -0,0 +1,233 @@
+package master
+
+import (
+	"distributed_cache/client"
+	"distributed_cache/common"
+	"distributed_cache/consistenthash"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type PeerState int
+
+const (
+	PeerAlive PeerState = iota
+	PeerSuspect
+	PeerDead // removed from the hash ring, still probed
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerAlive:
+		return "alive"
+	case PeerSuspect:
+		return "suspect"
+	case PeerDead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+func (s PeerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *PeerState) UnmarshalText(text []byte) error {
+	for _, state := range []PeerState{PeerAlive, PeerSuspect, PeerDead} {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown peer state: %s", text)
+}
+
+type PeerStatus struct {
+	Addr   string    `json:"addr"`
+	State  PeerState `json:"state"`
+	Missed int       `json:"missed"` // continuous missed heartbeats
+	Since  time.Time `json:"since"`  // time of the last state transition
+}
+
+type HeartbeatConfig struct {
+	Interval     time.Duration // probe interval
+	Timeout      time.Duration // probe timeout
+	SuspectAfter int           // missed heartbeats before the peer is suspect
+	DeadAfter    int           // missed heartbeats before the peer is removed from the ring
+}
+
+var DefaultHeartbeatConfig = HeartbeatConfig{
+	Interval:     time.Second,
+	Timeout:      500 * time.Millisecond,
+	SuspectAfter: 1,
+	DeadAfter:    3,
+}
+
+type heartbeat struct {
+	config HeartbeatConfig
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// StartHeartbeat probes all the registered peers periodically,
+// the dead peers are removed from the hash ring and re-added once they recover
+func (m *Master) StartHeartbeat(config HeartbeatConfig) error {
+	if config.Interval <= 0 || config.Timeout <= 0 || config.SuspectAfter <= 0 || config.DeadAfter < config.SuspectAfter {
+		return common.ErrPositiveParamNegative
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.heartbeat != nil {
+		m.heartbeat.Stop()
+	}
+	m.heartbeat = &heartbeat{
+		config: config,
+		stop:   make(chan struct{}),
+	}
+	go m.heartbeat.run(m)
+	return nil
+}
+
+func (m *Master) StopHeartbeat() {
+	m.Lock()
+	defer m.Unlock()
+	if m.heartbeat != nil {
+		m.heartbeat.Stop()
+		m.heartbeat = nil
+	}
+}
+
+func (h *heartbeat) Stop() {
+	h.once.Do(func() {
+		close(h.stop)
+	})
+}
+
+func (h *heartbeat) run(m *Master) {
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.probe(h.config)
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// probe all peers concurrently, then update the peer states,
+// only the leader probes and its transitions are replicated to the followers
+func (m *Master) probe(config HeartbeatConfig) {
+	if !m.IsLeader() {
+		return
+	}
+	m.RLock()
+	peers := make(map[string]*client.Client, len(m.peers))
+	for addr, peer := range m.peers {
+		peers[addr] = peer
+	}
+	m.RUnlock()
+
+	var (
+		wg      sync.WaitGroup
+		resMu   sync.Mutex
+		results = make(map[string]error, len(peers))
+	)
+	for addr, peer := range peers {
+		wg.Add(1)
+		go func(addr string, peer *client.Client) {
+			defer wg.Done()
+			err := peer.Health(config.Timeout)
+			resMu.Lock()
+			results[addr] = err
+			resMu.Unlock()
+		}(addr, peer)
+	}
+	wg.Wait()
+
+	m.Lock()
+	var transitions []command
+	for addr, err := range results {
+		status, ok := m.status[addr]
+		if !ok { // deregistered while probing
+			continue
+		}
+		state := status.State
+		if err == nil {
+			status.Missed = 0
+			state = PeerAlive
+		} else {
+			status.Missed++
+			m.log("Master: [HEARTBEAT] peer[%s] missed %d heartbeats, err: %v", addr, status.Missed, err)
+			if status.Missed >= config.DeadAfter {
+				state = PeerDead
+			} else if status.Missed >= config.SuspectAfter && status.State == PeerAlive {
+				state = PeerSuspect
+			}
+		}
+		if state == status.State {
+			continue
+		}
+		if m.replicator == nil {
+			m.transit(status, state)
+		} else {
+			transitions = append(transitions, command{Op: opTransit, Addrs: []string{addr}, State: state})
+		}
+	}
+	m.Unlock()
+	// the transitions are applied once they are committed
+	for _, cmd := range transitions {
+		if err := m.propose(cmd); err != nil {
+			m.log("Master: [ERROR] replicate peer[%s] -> %s: %v", cmd.Addrs[0], cmd.State, err)
+		}
+	}
+}
+
+// change the peer state and keep the hash ring consistent with it,
+// the keys are rebalanced if the ring changes, must hold the lock
+func (m *Master) transit(status *PeerStatus, state PeerState) {
+	if status.State == state {
+		return
+	}
+	var old *consistenthash.Map
+	var err error
+	var purge []string
+	switch {
+	case state == PeerDead:
+		old = m.register.Clone()
+		err = m.register.Delete(status.Addr)
+	case status.State == PeerDead:
+		old = m.register.Clone()
+		err = m.register.Add(status.Addr)
+		// the recovered peer may keep the values rewritten on the interim owners
+		purge = append(purge, status.Addr)
+	}
+	if err != nil {
+		m.log("Master: [ERROR] peer[%s] %s -> %s: %v", status.Addr, status.State, state, err)
+		return
+	}
+	m.log("Master: [HEARTBEAT] peer[%s] %s -> %s", status.Addr, status.State, state)
+	status.State = state
+	status.Since = time.Now()
+	if old != nil {
+		m.rebalance(old, nil, purge...)
+	}
+}
+
+// PeerStatuses returns the states of all registered peers in order
+func (m *Master) PeerStatuses() []PeerStatus {
+	m.RLock()
+	defer m.RUnlock()
+	statuses := make([]PeerStatus, 0, len(m.status))
+	for _, status := range m.status {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Addr < statuses[j].Addr
+	})
+	return statuses
+}
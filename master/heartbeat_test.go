@@ -0,0 +1,100 @@
+package master
+
+import (
+	"distributed_cache/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newHealthServer(healthy *atomic.Bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != common.HealthPath || !healthy.Load() {
+			http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+}
+
+func waitState(m *Master, addr string, state PeerState) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, status := range m.PeerStatuses() {
+			if status.Addr == addr && status.State == state {
+				return true
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestHeartbeat(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	server := newHealthServer(&healthy)
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	m := NewMaster(3, nil)
+	m.Register("http://", "/_Cache/", addr)
+	err := m.StartHeartbeat(HeartbeatConfig{
+		Interval:     5 * time.Millisecond,
+		Timeout:      50 * time.Millisecond,
+		SuspectAfter: 1,
+		DeadAfter:    3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.StopHeartbeat()
+
+	healthy.Store(false)
+	if !waitState(m, addr, PeerDead) {
+		t.Fatal("the unhealthy peer must be dead")
+	}
+	if !m.register.Empty() {
+		t.Fatal("the dead peer must be removed from the ring")
+	}
+	if _, err := m.Get("test", "key"); err != common.ErrNoPeerRegistered {
+		t.Fail()
+	}
+
+	healthy.Store(true)
+	if !waitState(m, addr, PeerAlive) {
+		t.Fatal("the recovered peer must be alive")
+	}
+	if m.register.PeerCount() != 1 {
+		t.Fatal("the recovered peer must be re-added to the ring")
+	}
+}
+
+func TestHeartbeatDeleteDeadPeer(t *testing.T) {
+	var healthy atomic.Bool
+	server := newHealthServer(&healthy)
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	m := NewMaster(3, nil)
+	m.Register("http://", "/_Cache/", addr)
+	m.StartHeartbeat(HeartbeatConfig{
+		Interval:     5 * time.Millisecond,
+		Timeout:      50 * time.Millisecond,
+		SuspectAfter: 1,
+		DeadAfter:    1,
+	})
+	defer m.StopHeartbeat()
+	if !waitState(m, addr, PeerDead) {
+		t.Fatal("the unhealthy peer must be dead")
+	}
+	if err := m.Register("http://", "/_Cache/", addr); err != common.ErrPeerRegistered {
+		t.Fail()
+	}
+	if err := m.Delete(addr); err != nil || len(m.PeerStatuses()) != 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,132 @@
+package master
+
+import (
+	"distributed_cache/common"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+var DefaultClusterPath = "/cluster/nodes"
+var DefaultClusterHealthPath = "/cluster/health"
+var DefaultClusterRebalancePath = "/cluster/rebalance"
+
+// body of the node registration request
+type NodeRequest struct {
+	Addr string `json:"addr"`
+}
+
+// ClusterHandler exposes the node registration api of the master
+//
+//	GET    /cluster/nodes        list the registered nodes
+//	POST   /cluster/nodes        register the node in the body {"addr": "ip:port"}
+//	DELETE /cluster/nodes/<addr> deregister the node
+//	GET    /cluster/health       list the heartbeat states of the nodes
+//	GET    /cluster/rebalance    progress of the latest rebalance
+type ClusterHandler struct {
+	master   *Master
+	basePath string
+	prefix   string // peer url prefix, e.g. http://
+	suffix   string // peer url suffix, e.g. /_Cache/
+}
+
+func NewClusterHandler(master *Master, prefix string, suffix string) *ClusterHandler {
+	return &ClusterHandler{
+		master:   master,
+		basePath: DefaultClusterPath,
+		prefix:   prefix,
+		suffix:   suffix,
+	}
+}
+
+func (h *ClusterHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	switch {
+	case path == DefaultClusterHealthPath:
+		if req.Method != http.MethodGet {
+			http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		h.serveHealth(resp)
+	case path == DefaultClusterRebalancePath:
+		if req.Method != http.MethodGet {
+			http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(resp).Encode(h.master.RebalanceStatus())
+	case path == h.basePath:
+		switch req.Method {
+		case http.MethodGet:
+			h.serveList(resp)
+		case http.MethodPost:
+			h.serveRegister(resp, req)
+		default:
+			http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+		}
+	case strings.HasPrefix(path, h.basePath+"/"):
+		if req.Method != http.MethodDelete {
+			http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		h.serveDeregister(resp, req, path[len(h.basePath)+1:])
+	default:
+		http.Error(resp, "unexpected path: "+req.URL.Path, http.StatusNotFound)
+	}
+}
+
+func (h *ClusterHandler) serveList(resp http.ResponseWriter) {
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(h.master.Peers())
+}
+
+func (h *ClusterHandler) serveHealth(resp http.ResponseWriter) {
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(h.master.PeerStatuses())
+}
+
+func (h *ClusterHandler) serveRegister(resp http.ResponseWriter, req *http.Request) {
+	var node NodeRequest
+	if err := json.NewDecoder(req.Body).Decode(&node); err != nil || node.Addr == "" {
+		http.Error(resp, "bad request body", http.StatusBadRequest)
+		return
+	}
+	h.master.log("Master: [REGISTER] node[%s]", node.Addr)
+	err := h.master.Register(h.prefix, h.suffix, node.Addr)
+	switch err {
+	case nil:
+		resp.WriteHeader(http.StatusCreated)
+	case common.ErrPeerRegistered:
+		http.Error(resp, err.Error(), http.StatusConflict)
+	case common.ErrNotLeader:
+		RedirectToLeader(resp, req, h.master)
+	default:
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *ClusterHandler) serveDeregister(resp http.ResponseWriter, req *http.Request, addr string) {
+	h.master.log("Master: [DEREGISTER] node[%s]", addr)
+	err := h.master.Delete(addr)
+	switch err {
+	case nil:
+		resp.WriteHeader(http.StatusOK)
+	case common.ErrPeerNotRegistered:
+		http.Error(resp, err.Error(), http.StatusNotFound)
+	case common.ErrNotLeader:
+		RedirectToLeader(resp, req, h.master)
+	default:
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// RedirectToLeader redirects the write sent to the follower,
+// the request body is resent by the client on 307
+func RedirectToLeader(resp http.ResponseWriter, req *http.Request, m *Master) {
+	leader := m.Leader()
+	if leader == "" {
+		http.Error(resp, common.ErrNotLeader.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(resp, req, "http://"+leader+req.URL.RequestURI(), http.StatusTemporaryRedirect)
+}
@@ -0,0 +1,62 @@
+package master
+
+import (
+	"distributed_cache/client"
+	"distributed_cache/common"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClusterRegister(t *testing.T) {
+	m := NewMaster(3, nil)
+	server := httptest.NewServer(NewClusterHandler(m, "http://", "/_Cache/"))
+	defer server.Close()
+	c := client.NewMasterClient(server.URL + DefaultClusterPath)
+
+	if err := c.Register("localhost:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Register("localhost:8001"); err != common.ErrPeerRegistered {
+		t.Fail()
+	}
+	c.Register("localhost:8002")
+	nodes, err := c.Nodes()
+	if err != nil || !reflect.DeepEqual(nodes, []string{"localhost:8001", "localhost:8002"}) {
+		t.Fatal(nodes, err)
+	}
+
+	if err := c.Deregister("localhost:8001"); err != nil {
+		t.Fail()
+	}
+	if err := c.Deregister("localhost:8001"); err != common.ErrPeerNotRegistered {
+		t.Fail()
+	}
+	if nodes, _ := c.Nodes(); !reflect.DeepEqual(nodes, []string{"localhost:8002"}) {
+		t.Fail()
+	}
+}
+
+func TestClusterRegisterWithRetry(t *testing.T) {
+	m := NewMaster(3, nil)
+	server := httptest.NewUnstartedServer(NewClusterHandler(m, "http://", "/_Cache/"))
+	defer server.Close()
+	c := client.NewMasterClient("http://" + server.Listener.Addr().String() + DefaultClusterPath)
+
+	done := make(chan error)
+	go func() {
+		done <- c.RegisterWithRetry("localhost:8001", 10*time.Millisecond, nil)
+	}()
+	// the master starts after the node
+	time.Sleep(30 * time.Millisecond)
+	server.Start()
+	select {
+	case err := <-done:
+		if err != nil || len(m.Peers()) != 1 {
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Fatal("node is not registered after the master started")
+	}
+}
@@ -0,0 +1,51 @@
+package master
+
+import (
+	"distributed_cache/client"
+	"distributed_cache/metrics"
+	"time"
+)
+
+// the requests routed to the cache peers
+type routeMetrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+}
+
+func newRouteMetrics() *routeMetrics {
+	return &routeMetrics{
+		requests: metrics.NewCounterVec("master_routed_requests_total", "Requests routed to the cache peers by peer, operation and result.", "peer", "op", "result"),
+		duration: metrics.NewHistogramVec("master_routed_request_duration_seconds", "Latency of the requests routed to the cache peers.", nil, "peer", "op"),
+	}
+}
+
+// call the peer and record the routing decision
+func (m *Master) route(peer *client.Client, op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.routes.requests.With(peer.ServerAddr(), op, result).Inc()
+	m.routes.duration.With(peer.ServerAddr(), op).ObserveSince(start)
+	return err
+}
+
+// the peers by the heartbeat state
+func (m *Master) collectPeers(w *metrics.Writer) {
+	counts := make(map[PeerState]int)
+	for _, status := range m.PeerStatuses() {
+		counts[status.State]++
+	}
+	w.Header("master_peers", "Registered cache peers by the heartbeat state.", "gauge")
+	for _, state := range []PeerState{PeerAlive, PeerSuspect, PeerDead} {
+		w.Sample("master_peers", float64(counts[state]), metrics.Label{Name: "state", Value: state.String()})
+	}
+}
+
+// Metrics exposes the routing and the membership metrics of the master,
+// more collectors can be registered on it, e.g. by metrics.InstrumentHandler
+func (m *Master) Metrics() *metrics.Registry {
+	return m.metrics
+}
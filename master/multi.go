@@ -0,0 +1,119 @@
+package master
+
+import (
+	"context"
+	"distributed_cache/client"
+	"distributed_cache/common"
+	"errors"
+	"sync"
+)
+
+func (m *Master) GetMulti(serviceName string, keys []string) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), common.RequestTimeout)
+	defer cancel()
+	return m.GetMultiContext(ctx, serviceName, keys)
+}
+
+// GetMultiContext groups the keys by the first replica which is not suspected,
+// like Get, and reads the groups in parallel, one round trip per peer,
+// the group failed on a replica is read again from the next replicas of its keys,
+// the keys not in the db are missing in the result,
+// the values read are returned with the first error of the groups no replica serves,
+// the service reading from R > 1 replicas reads every key by the quorum like Get,
+// as the batch has no versions to tell the freshest value
+func (m *Master) GetMultiContext(ctx context.Context, serviceName string, keys []string) (map[string][]byte, error) {
+	m.log("Master: [GET_MULTI] service[%s] %d keys", serviceName, len(keys))
+	c := m.consistencyOf(serviceName)
+	replicas, err := m.replicasOf(keys, c.N)
+	if err != nil {
+		return nil, err
+	}
+	if c.R > 1 {
+		return m.quorumGetMulti(ctx, replicas, c.R, serviceName)
+	}
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		values   = make(map[string][]byte, len(keys))
+		firstErr error
+	)
+	// read the keys from their attempt-th replica
+	var read func(keys []string, attempt int)
+	read = func(keys []string, attempt int) {
+		groups := make(map[*client.Client][]string)
+		for _, key := range keys {
+			peer := replicas[key][attempt]
+			groups[peer] = append(groups[peer], key)
+		}
+		for peer, group := range groups {
+			wg.Add(1)
+			go func(peer *client.Client, group []string) {
+				defer wg.Done()
+				m.log("direct %d keys to %s", len(group), peer.ServerAddr())
+				var got map[string][]byte
+				err := m.route(peer, "get_multi", func() (err error) {
+					got, err = peer.GetMultiContext(ctx, serviceName, group)
+					return err
+				})
+				// the keys of the group have the same number of replicas
+				if err != nil && ctx.Err() == nil && attempt+1 < len(replicas[group[0]]) {
+					read(group, attempt+1)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				for key, value := range got {
+					values[key] = value
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}(peer, group)
+		}
+	}
+	read(keys, 0)
+	wg.Wait()
+	return values, firstErr
+}
+
+// read every key from r of its replicas in parallel
+func (m *Master) quorumGetMulti(ctx context.Context, replicas map[string][]*client.Client, r int, serviceName string) (map[string][]byte, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		values   = make(map[string][]byte, len(replicas))
+		firstErr error
+	)
+	for key, peers := range replicas {
+		wg.Add(1)
+		go func(key string, peers []*client.Client) {
+			defer wg.Done()
+			value, err := m.quorumGet(ctx, peers, min(r, len(peers)), serviceName, key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				values[key] = value
+			} else if !errors.Is(err, common.ErrKeyNotInDB) && firstErr == nil {
+				firstErr = err
+			}
+		}(key, peers)
+	}
+	wg.Wait()
+	return values, firstErr
+}
+
+// the replicas of every key in the order Get tries them
+func (m *Master) replicasOf(keys []string, n int) (map[string][]*client.Client, error) {
+	replicas := make(map[string][]*client.Client, len(keys))
+	for _, key := range keys {
+		if _, ok := replicas[key]; ok {
+			continue
+		}
+		peers, err := m.direct(key, n)
+		if err != nil {
+			return nil, err
+		}
+		replicas[key] = peers
+	}
+	return replicas, nil
+}
@@ -0,0 +1,139 @@
+package master
+
+import (
+	"context"
+	"distributed_cache/client"
+	"distributed_cache/common"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Consistency of a service
+// N: replicas of every key
+// R: responses required by Get, the freshest value of them is returned
+// W: acknowledgements required by Put and Invalidate
+// R + W > N gives the strong consistency, R = 1 is the fastest but may be stale
+type Consistency struct {
+	N int
+	R int
+	W int
+}
+
+func (c Consistency) valid() bool {
+	return c.N > 0 && c.R > 0 && c.W > 0 && c.R <= c.N && c.W <= c.N
+}
+
+// the consistency of the service, panics if it is invalid,
+// use SetConsistency to handle the error of the consistency given at runtime
+func WithConsistency(serviceName string, c Consistency) Option {
+	return func(m *Master) {
+		if err := m.SetConsistency(serviceName, c); err != nil {
+			panic(fmt.Sprintf("master: invalid consistency %+v of service %s: %v", c, serviceName, err))
+		}
+	}
+}
+
+func (m *Master) SetConsistency(serviceName string, c Consistency) error {
+	if !c.valid() {
+		return common.ErrPositiveParamNegative
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.consistency[serviceName] = c
+	return nil
+}
+
+// the consistency of the service, by default all replicas must acknowledge
+// the write and read from the first one responds
+func (m *Master) consistencyOf(serviceName string) Consistency {
+	m.RLock()
+	defer m.RUnlock()
+	if c, ok := m.consistency[serviceName]; ok {
+		return c
+	}
+	return Consistency{N: m.factor, R: 1, W: m.factor}
+}
+
+// nextVersion generates the increasing write version from the wall clock
+func (m *Master) nextVersion() uint64 {
+	for {
+		last := atomic.LoadUint64(&m.version)
+		next := uint64(time.Now().UnixNano())
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&m.version, last, next) {
+			return next
+		}
+	}
+}
+
+// call fn on all peers concurrently, return once need of them succeed,
+// the remaining calls keep running in background,
+// the quorum missed because the failed peers all miss the key is reported as not in db
+func quorum(peers []*client.Client, need int, fn func(peer *client.Client) error) error {
+	errs := make(chan error, len(peers))
+	for _, peer := range peers {
+		go func(peer *client.Client) {
+			errs <- fn(peer)
+		}(peer)
+	}
+	acks, fails, notFound := 0, 0, 0
+	for range peers {
+		if err := <-errs; err != nil {
+			fails++
+			if errors.Is(err, common.ErrKeyNotInDB) {
+				notFound++
+			}
+			if len(peers)-fails < need {
+				break
+			}
+			continue
+		}
+		acks++
+		if acks >= need {
+			return nil
+		}
+	}
+	if notFound > 0 && notFound == fails {
+		return common.ErrKeyNotInDB
+	}
+	return common.ErrQuorumNotReached
+}
+
+// read the key from the replicas, return the freshest value of the first r responses
+func (m *Master) quorumGet(ctx context.Context, peers []*client.Client, r int, serviceName string, key string) ([]byte, error) {
+	var (
+		mu      sync.Mutex
+		value   []byte
+		version uint64
+		found   bool
+	)
+	err := quorum(peers, r, func(peer *client.Client) error {
+		m.log("direct to %s", peer.ServerAddr())
+		var v []byte
+		var ver uint64
+		err := m.route(peer, "get", func() (err error) {
+			v, ver, err = peer.GetVersionedContext(ctx, serviceName, key)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !found || ver > version {
+			value, version, found = v, ver, true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return value, nil
+}
@@ -0,0 +1,256 @@
+package master
+
+import (
+	"context"
+	"distributed_cache/client"
+	"distributed_cache/consistenthash"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type RebalanceConfig struct {
+	Rate int // max entries per second migrated by each node, <= 0 means unlimited
+	// max duration of the migration of one source node, <= 0 means DefaultMigrateTimeout,
+	// the timed out task is reported as failed and the rebalance goes on
+	TaskTimeout time.Duration
+}
+
+var DefaultMigrateTimeout = time.Minute
+
+// migrate the keys between the peers when the ring membership changes
+func WithRebalance(config RebalanceConfig) Option {
+	return func(m *Master) {
+		m.rebalanceConfig = &config
+	}
+}
+
+// progress of the latest rebalance
+type RebalanceStatus struct {
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Tasks    int       `json:"tasks"`
+	Done     int       `json:"done"`
+	Failed   int       `json:"failed"`
+	Moved    int       `json:"moved"`
+	Evicted  int       `json:"evicted"`
+	Errors   []string  `json:"errors,omitempty"`
+}
+
+// the migration done by one source peer
+type rebalanceTask struct {
+	source  string
+	targets []string
+	evict   bool
+	purge   bool // evict the stale entries before the ranges are copied back
+	ranges  []consistenthash.Range
+	// the services of the task, see client.MigrateRequest
+	services []string
+	skip     []string
+}
+
+// the order of the tasks: purge, copy, then evict
+func (t *rebalanceTask) phase() int {
+	switch {
+	case t.purge:
+		return 0
+	case len(t.targets) > 0:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// split the moved ranges into the tasks of the old owners: the first reachable
+// old owner copies the range to the new owners, the old owners which no longer
+// own the range evict it, the down peers are not asked for anything,
+// the purged peers drop what they cached in the ranges they gain,
+// e.g. the recovered peer whose entries were rewritten on the interim owners
+func rebalanceTasks(moves []consistenthash.Move, down map[string]bool, purge map[string]bool) []*rebalanceTask {
+	tasks := make(map[string]*rebalanceTask)
+	addTask := func(source string, targets []string, evict bool, purge bool, r consistenthash.Range) {
+		id := fmt.Sprintf("%s|%s|%v|%v", source, strings.Join(targets, ","), evict, purge)
+		task, ok := tasks[id]
+		if !ok {
+			task = &rebalanceTask{source: source, targets: targets, evict: evict, purge: purge}
+			tasks[id] = task
+		}
+		task.ranges = append(task.ranges, r)
+	}
+	for _, move := range moves {
+		added, removed := subtract(move.To, move.From), subtract(move.From, move.To)
+		isRemoved := make(map[string]bool, len(removed))
+		for _, peer := range removed {
+			isRemoved[peer] = true
+		}
+		for _, peer := range added {
+			if purge[peer] {
+				addTask(peer, nil, true, true, move.Range)
+			}
+		}
+		source := ""
+		if len(added) > 0 {
+			for _, peer := range move.From {
+				if !down[peer] {
+					source = peer
+					break
+				}
+			}
+			if source != "" {
+				addTask(source, added, isRemoved[source], false, move.Range)
+			}
+		}
+		for _, peer := range removed {
+			if peer != source && !down[peer] {
+				addTask(peer, nil, true, false, move.Range)
+			}
+		}
+	}
+	var res []*rebalanceTask
+	for _, task := range tasks {
+		res = append(res, task)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].phase() != res[j].phase() {
+			return res[i].phase() < res[j].phase()
+		}
+		return res[i].source < res[j].source
+	})
+	return res
+}
+
+// elements of a which are not in b
+func subtract(a []string, b []string) []string {
+	var res []string
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+// start the rebalance from the old ring to the current one in background,
+// oldPeers are the clients of the peers in the old ring, the purged peers
+// drop their entries in the gained ranges first, must hold the lock
+func (m *Master) rebalance(old *consistenthash.Map, oldPeers map[string]*client.Client, purge ...string) {
+	// every master applies the membership change, only the leader migrates the keys
+	if m.rebalanceConfig == nil || !m.IsLeader() {
+		return
+	}
+	new := m.register.Clone()
+	peers := make(map[string]*client.Client, len(oldPeers)+len(m.peers))
+	for addr, peer := range oldPeers {
+		peers[addr] = peer
+	}
+	for addr, peer := range m.peers {
+		peers[addr] = peer
+	}
+	down := make(map[string]bool)
+	for addr, status := range m.status {
+		if status.State == PeerDead {
+			down[addr] = true
+		}
+	}
+	purged := make(map[string]bool, len(purge))
+	for _, addr := range purge {
+		purged[addr] = true
+	}
+	// the replicas of the services with their own N are placed differently
+	groups := map[int][]string{m.factor: nil}
+	var custom []string
+	for name, c := range m.consistency {
+		if c.N != m.factor {
+			groups[c.N] = append(groups[c.N], name)
+			custom = append(custom, name)
+		}
+	}
+	var tasks []*rebalanceTask
+	for n, services := range groups {
+		for _, task := range rebalanceTasks(consistenthash.Diff(old, new, n), down, purged) {
+			if n == m.factor {
+				task.skip = custom
+			} else {
+				task.services = services
+			}
+			tasks = append(tasks, task)
+		}
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].phase() < tasks[j].phase()
+	})
+	if len(tasks) == 0 {
+		return
+	}
+	go m.runRebalance(tasks, peers, *m.rebalanceConfig)
+}
+
+func (m *Master) runRebalance(tasks []*rebalanceTask, peers map[string]*client.Client, config RebalanceConfig) {
+	// one rebalance at a time
+	m.rebalanceMu.Lock()
+	defer m.rebalanceMu.Unlock()
+
+	m.Lock()
+	m.rebalanceStatus = RebalanceStatus{Running: true, Started: time.Now(), Tasks: len(tasks)}
+	m.Unlock()
+	m.log("Master: [REBALANCE] start %d tasks", len(tasks))
+
+	timeout := config.TaskTimeout
+	if timeout <= 0 {
+		timeout = DefaultMigrateTimeout
+	}
+	for _, task := range tasks {
+		targets := make([]string, len(task.targets))
+		for i, target := range task.targets {
+			targets[i] = peers[target].ServerAddr()
+		}
+		// the hung source node must not block the later rebalances
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		result, err := peers[task.source].MigrateContext(ctx, client.MigrateRequest{
+			Ranges:   task.ranges,
+			Targets:  targets,
+			Evict:    task.evict,
+			Rate:     config.Rate,
+			Services: task.services,
+			Skip:     task.skip,
+		})
+		cancel()
+		m.Lock()
+		status := &m.rebalanceStatus
+		status.Done++
+		status.Moved += result.Moved
+		status.Evicted += result.Evicted
+		if err == nil && result.Failed > 0 {
+			err = fmt.Errorf("%d entries failed to migrate", result.Failed)
+		}
+		if err != nil {
+			status.Failed++
+			status.Errors = append(status.Errors, fmt.Sprintf("%s: %v", task.source, err))
+		}
+		m.Unlock()
+		m.log("Master: [REBALANCE] %s -> %v: moved %d, evicted %d, err %v", task.source, task.targets, result.Moved, result.Evicted, err)
+	}
+
+	m.Lock()
+	m.rebalanceStatus.Running = false
+	m.rebalanceStatus.Finished = time.Now()
+	m.Unlock()
+	m.log("Master: [REBALANCE] finished")
+}
+
+func (m *Master) RebalanceStatus() RebalanceStatus {
+	m.RLock()
+	defer m.RUnlock()
+	status := m.rebalanceStatus
+	status.Errors = append([]string(nil), status.Errors...)
+	return status
+}
@@ -0,0 +1,235 @@
+package master
+
+import (
+	"distributed_cache/client"
+	"distributed_cache/consistenthash"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRebalanceTasks(t *testing.T) {
+	moves := []consistenthash.Move{
+		{Range: consistenthash.Range{Start: 23, End: 33}, From: []string{"3", "1"}, To: []string{"3", "4"}},
+		{Range: consistenthash.Range{Start: 33, End: 43}, From: []string{"1", "2"}, To: []string{"4", "1"}},
+	}
+	tasks := rebalanceTasks(moves, nil, nil)
+	ans := []*rebalanceTask{
+		// "3" copies (23, 33] to "4", "1" evicts it
+		{source: "3", targets: []string{"4"}, evict: false, ranges: []consistenthash.Range{{Start: 23, End: 33}}},
+		// "1" copies (33, 43] to "4", "2" evicts it
+		{source: "1", targets: []string{"4"}, evict: false, ranges: []consistenthash.Range{{Start: 33, End: 43}}},
+		{source: "1", targets: nil, evict: true, ranges: []consistenthash.Range{{Start: 23, End: 33}}},
+		{source: "2", targets: nil, evict: true, ranges: []consistenthash.Range{{Start: 33, End: 43}}},
+	}
+	// copy tasks come first
+	if len(tasks) != len(ans) {
+		t.Fatalf("get %d tasks, should be %d", len(tasks), len(ans))
+	}
+	for _, task := range ans[:2] {
+		if !containsTask(tasks[:2], task) {
+			t.Fatalf("the copy task %+v is missing", task)
+		}
+	}
+	for _, task := range ans[2:] {
+		if !containsTask(tasks[2:], task) {
+			t.Fatalf("the evict task %+v is missing", task)
+		}
+	}
+
+	// the only owner moves its range away
+	tasks = rebalanceTasks([]consistenthash.Move{
+		{Range: consistenthash.Range{Start: 33, End: 43}, From: []string{"1"}, To: []string{"4"}},
+	}, nil, nil)
+	if len(tasks) != 1 || !tasks[0].evict || !reflect.DeepEqual(tasks[0].targets, []string{"4"}) {
+		t.Fail()
+	}
+
+	// the dead owner is skipped, the next old owner copies the range
+	tasks = rebalanceTasks([]consistenthash.Move{
+		{Range: consistenthash.Range{Start: 33, End: 43}, From: []string{"1", "2"}, To: []string{"2", "3"}},
+	}, map[string]bool{"1": true}, nil)
+	ans = []*rebalanceTask{
+		{source: "2", targets: []string{"3"}, evict: false, ranges: []consistenthash.Range{{Start: 33, End: 43}}},
+	}
+	if !reflect.DeepEqual(tasks, ans) {
+		t.Fatalf("get tasks %+v", tasks)
+	}
+
+	// the recovered peer drops its stale entries before the range is copied back
+	tasks = rebalanceTasks([]consistenthash.Move{
+		{Range: consistenthash.Range{Start: 33, End: 43}, From: []string{"2"}, To: []string{"1"}},
+	}, nil, map[string]bool{"1": true})
+	ans = []*rebalanceTask{
+		{source: "1", targets: nil, evict: true, purge: true, ranges: []consistenthash.Range{{Start: 33, End: 43}}},
+		{source: "2", targets: []string{"1"}, evict: true, ranges: []consistenthash.Range{{Start: 33, End: 43}}},
+	}
+	if !reflect.DeepEqual(tasks, ans) {
+		t.Fatalf("get tasks %+v", tasks)
+	}
+}
+
+func containsTask(tasks []*rebalanceTask, task *rebalanceTask) bool {
+	for _, t := range tasks {
+		if reflect.DeepEqual(t, task) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMasterRebalance(t *testing.T) {
+	m, nodes := newTestCluster(t, 1, WithRebalance(RebalanceConfig{Rate: 100}))
+	newNode := newFakeNode()
+	defer newNode.Close()
+	if err := m.Register("http://", "/_Cache/", newNode.addr()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for status := m.RebalanceStatus(); status.Running || status.Tasks == 0; status = m.RebalanceStatus() {
+		if time.Now().After(deadline) {
+			t.Fatal("the rebalance is not finished")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	status := m.RebalanceStatus()
+	if status.Failed != 0 || status.Done != status.Tasks || status.Moved != status.Tasks {
+		t.Fatalf("unexpected rebalance status %+v", status)
+	}
+	// the old owners are asked to migrate the entries to the new node
+	requests := 0
+	for _, node := range nodes {
+		node.Lock()
+		for _, migrate := range node.migrates {
+			if len(migrate.Targets) == 1 && migrate.Targets[0] == "http://"+newNode.addr()+"/_Cache/" {
+				requests++
+				if !migrate.Evict || migrate.Rate != 100 || len(migrate.Ranges) == 0 {
+					t.Fail()
+				}
+			}
+		}
+		node.Unlock()
+	}
+	if requests == 0 {
+		t.Fatal("no migrate request sent to the old owners")
+	}
+}
+
+func TestMasterRebalanceTimeout(t *testing.T) {
+	m, nodes := newTestCluster(t, 1, WithRebalance(RebalanceConfig{TaskTimeout: 50 * time.Millisecond}))
+	nodes[0].Lock()
+	nodes[0].hang = true
+	nodes[0].Unlock()
+	newNode := newFakeNode()
+	defer newNode.Close()
+	if err := m.Register("http://", "/_Cache/", newNode.addr()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for status := m.RebalanceStatus(); status.Running || status.Tasks == 0; status = m.RebalanceStatus() {
+		if time.Now().After(deadline) {
+			t.Fatal("the hung source node blocks the rebalance")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// the timed out task is reported
+	if status := m.RebalanceStatus(); status.Failed != status.Tasks || len(status.Errors) != status.Tasks {
+		t.Fatalf("unexpected rebalance status %+v", status)
+	}
+}
+
+func TestRebalanceConsistency(t *testing.T) {
+	start := time.Now()
+	m, nodes := newTestCluster(t, 3, WithRebalance(RebalanceConfig{}),
+		WithConsistency("wide", Consistency{N: 3, R: 1, W: 3}))
+	waitRebalance(t, m, start)
+	for _, node := range nodes {
+		node.Lock()
+		node.migrates = nil
+		node.Unlock()
+	}
+	start = time.Now()
+	newNode := newFakeNode()
+	defer newNode.Close()
+	if err := m.Register("http://", "/_Cache/", newNode.addr()); err != nil {
+		t.Fatal(err)
+	}
+	waitRebalance(t, m, start)
+	// the ranges of the service with N = 3 are diffed on their own
+	wide, others := 0, 0
+	for _, node := range nodes {
+		node.Lock()
+		for _, migrate := range node.migrates {
+			switch {
+			case reflect.DeepEqual(migrate.Services, []string{"wide"}) && migrate.Skip == nil:
+				wide++
+			case migrate.Services == nil && reflect.DeepEqual(migrate.Skip, []string{"wide"}):
+				others++
+			default:
+				t.Errorf("unexpected migrate request %+v", migrate)
+			}
+		}
+		node.Unlock()
+	}
+	if wide == 0 || others == 0 {
+		t.Fatalf("get %d migrates of the wide service and %d of the others", wide, others)
+	}
+}
+
+func TestHeartbeatRebalance(t *testing.T) {
+	start := time.Now()
+	m, nodes := newTestCluster(t, 2, WithRebalance(RebalanceConfig{}))
+	waitRebalance(t, m, start)
+	failed, interim := nodes[0], nodes[1]
+	for _, node := range nodes {
+		node.Lock()
+		node.migrates = nil
+		node.Unlock()
+	}
+	err := m.StartHeartbeat(HeartbeatConfig{
+		Interval:     5 * time.Millisecond,
+		Timeout:      50 * time.Millisecond,
+		SuspectAfter: 1,
+		DeadAfter:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.StopHeartbeat()
+
+	failed.setDown(true)
+	if !waitState(m, failed.addr(), PeerDead) {
+		t.Fatal("the unhealthy peer must be dead")
+	}
+	recovered := time.Now()
+	failed.setDown(false)
+	if !waitState(m, failed.addr(), PeerAlive) {
+		t.Fatal("the recovered peer must be alive")
+	}
+	waitRebalance(t, m, recovered)
+	// the recovered peer purges the ranges it gets back,
+	// then the interim owner copies them back and evicts them
+	failed.Lock()
+	migrates := append([]client.MigrateRequest(nil), failed.migrates...)
+	failed.Unlock()
+	if len(migrates) != 1 || !migrates[0].Evict || len(migrates[0].Targets) != 0 {
+		t.Fatalf("the recovered peer must purge its ranges, get %+v", migrates)
+	}
+	interim.Lock()
+	defer interim.Unlock()
+	if len(interim.migrates) != 1 || !interim.migrates[0].Evict ||
+		!reflect.DeepEqual(interim.migrates[0].Targets, []string{"http://" + failed.addr() + "/_Cache/"}) {
+		t.Fatalf("the interim owner must copy the ranges back, get %+v", interim.migrates)
+	}
+}
+
+// wait for the rebalance started after the time
+func waitRebalance(t *testing.T, m *Master, after time.Time) {
+	deadline := time.Now().Add(time.Second)
+	for status := m.RebalanceStatus(); status.Running || !status.Finished.After(after); status = m.RebalanceStatus() {
+		if time.Now().After(deadline) {
+			t.Fatal("the rebalance is not finished")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,86 @@
+package master
+
+import (
+	"distributed_cache/common"
+	"encoding/json"
+	"fmt"
+)
+
+// Replicator replicates the membership changes through a consensus log,
+// the committed commands are applied by Master.Apply on every master,
+// raft.Node implements it
+type Replicator interface {
+	Propose(command []byte) error
+	IsLeader() bool
+	Leader() string
+}
+
+const (
+	opRegister = "register"
+	opDelete   = "delete"
+	opTransit  = "transit"
+)
+
+// the membership change in the log
+type command struct {
+	Op     string    `json:"op"`
+	Prefix string    `json:"prefix,omitempty"`
+	Suffix string    `json:"suffix,omitempty"`
+	Addrs  []string  `json:"addrs"`
+	State  PeerState `json:"state"` // the new state of the transit
+}
+
+// set the replicator, must be called before the master is used,
+// then Register and Delete only succeed on the leader and the followers
+// serve the read-only routing
+func (m *Master) SetReplicator(r Replicator) {
+	m.replicator = r
+}
+
+// whether the master accepts the writes, the master without the replicator is always the leader
+func (m *Master) IsLeader() bool {
+	return m.replicator == nil || m.replicator.IsLeader()
+}
+
+// addr of the leader, empty if it is unknown or the master is not replicated
+func (m *Master) Leader() string {
+	if m.replicator == nil {
+		return ""
+	}
+	return m.replicator.Leader()
+}
+
+func (m *Master) propose(cmd command) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	return m.replicator.Propose(data)
+}
+
+// Apply implements raft.StateMachine
+func (m *Master) Apply(data []byte) error {
+	var cmd command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return err
+	}
+	switch cmd.Op {
+	case opRegister:
+		return m.addPeers(cmd.Prefix, cmd.Suffix, cmd.Addrs...)
+	case opDelete:
+		return m.removePeers(cmd.Addrs...)
+	case opTransit:
+		m.Lock()
+		defer m.Unlock()
+		for _, addr := range cmd.Addrs {
+			status, ok := m.status[addr]
+			if !ok {
+				return common.ErrPeerNotRegistered
+			}
+			m.transit(status, cmd.State)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command: %s", cmd.Op)
+	}
+}
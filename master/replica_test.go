@@ -0,0 +1,187 @@
+package master
+
+import (
+	"distributed_cache/client"
+	"distributed_cache/common"
+	"distributed_cache/raft"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testRaftConfig = raft.Config{
+	HeartbeatInterval: 10 * time.Millisecond,
+	ElectionTimeout:   50 * time.Millisecond,
+	RPCTimeout:        30 * time.Millisecond,
+	ProposeTimeout:    time.Second,
+}
+
+type replicatedMaster struct {
+	*Master
+	node   *raft.Node
+	server *httptest.Server
+}
+
+func (m *replicatedMaster) close() {
+	m.StopHeartbeat()
+	m.node.Stop()
+	m.server.Close()
+}
+
+func newReplicatedMasters(t *testing.T, size int) []*replicatedMaster {
+	var servers []*httptest.Server
+	var addrs []string
+	for i := 0; i < size; i++ {
+		server := httptest.NewUnstartedServer(nil)
+		servers = append(servers, server)
+		addrs = append(addrs, server.Listener.Addr().String())
+	}
+	var masters []*replicatedMaster
+	for i, server := range servers {
+		m := NewMaster(3, nil)
+		node := raft.NewNode(addrs[i], addrs, m, testRaftConfig)
+		m.SetReplicator(node)
+		cluster := NewClusterHandler(m, "http://", "/_Cache/")
+		mux := http.NewServeMux()
+		mux.Handle(raft.DefaultPath, node)
+		mux.Handle(DefaultClusterPath, cluster)
+		mux.Handle(DefaultClusterPath+"/", cluster)
+		server.Config.Handler = mux
+		server.Start()
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		rm := &replicatedMaster{Master: m, node: node, server: server}
+		t.Cleanup(rm.close)
+		masters = append(masters, rm)
+	}
+	return masters
+}
+
+func waitMasterLeader(masters []*replicatedMaster) *replicatedMaster {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, m := range masters {
+			if m.IsLeader() && knowLeader(masters, m.node.Self()) {
+				return m
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// the followers must know the leader as well
+func knowLeader(masters []*replicatedMaster, leader string) bool {
+	for _, m := range masters {
+		if m.Leader() != leader {
+			return false
+		}
+	}
+	return true
+}
+
+func waitPeers(masters []*replicatedMaster, want []string) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		done := true
+		for _, m := range masters {
+			if !reflect.DeepEqual(m.Peers(), want) {
+				done = false
+			}
+		}
+		if done {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestReplicatedMembership(t *testing.T) {
+	masters := newReplicatedMasters(t, 3)
+	leader := waitMasterLeader(masters)
+	if leader == nil {
+		t.Fatal("no leader elected")
+	}
+	var follower *replicatedMaster
+	for _, m := range masters {
+		if m != leader {
+			follower = m
+		}
+	}
+	node := newFakeNode()
+	defer node.Close()
+	node.set("key", []byte("value"), 1)
+
+	if err := follower.Register("http://", "/_Cache/", node.addr()); err != common.ErrNotLeader {
+		t.Fatal("the follower must reject the registration")
+	}
+	// the registration sent to the follower is redirected to the leader
+	c := client.NewMasterClient(follower.server.URL + DefaultClusterPath)
+	if err := c.Register(node.addr()); err != nil {
+		t.Fatal(err)
+	}
+	if !waitPeers(masters, []string{node.addr()}) {
+		t.Fatal("the membership must be replicated to all masters")
+	}
+	if err := c.Register(node.addr()); err != common.ErrPeerRegistered {
+		t.Fatal("the duplicated registration must be rejected by the leader")
+	}
+
+	// the follower serves the read-only routing
+	if value, err := follower.Get("test", "key"); err != nil || string(value) != "value" {
+		t.Fatal(value, err)
+	}
+	if err := follower.Put("test", "key", []byte("new")); err != common.ErrNotLeader {
+		t.Fatal("the follower must reject the write")
+	}
+	if err := leader.Put("test", "key", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the heartbeat transitions of the leader are replicated
+	for _, m := range masters {
+		m.StartHeartbeat(HeartbeatConfig{
+			Interval:     5 * time.Millisecond,
+			Timeout:      50 * time.Millisecond,
+			SuspectAfter: 1,
+			DeadAfter:    2,
+		})
+	}
+	node.setDown(true)
+	deadline := time.Now().Add(time.Second)
+	for follower.PeerStatuses()[0].State != PeerDead {
+		if time.Now().After(deadline) {
+			t.Fatal("the dead node must be removed from the ring of the follower")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := follower.Get("test", "key"); err != common.ErrNoPeerRegistered {
+		t.Fatal(err)
+	}
+
+	if err := c.Deregister(node.addr()); err != nil {
+		t.Fatal(err)
+	}
+	if !waitPeers(masters, []string{}) {
+		t.Fatal("the deregistration must be replicated to all masters")
+	}
+}
+
+func TestCommandState(t *testing.T) {
+	m := NewMaster(3, nil)
+	m.Register("http://", "/_Cache/", "localhost:8001")
+	data := []byte(`{"op":"transit","addrs":["localhost:8001"],"state":"dead"}`)
+	if err := m.Apply(data); err != nil {
+		t.Fatal(err)
+	}
+	if !m.register.Empty() || m.PeerStatuses()[0].State != PeerDead {
+		t.Fail()
+	}
+	if err := m.Apply([]byte(`{"op":"transit","addrs":["localhost:8001"],"state":"gone"}`)); err == nil {
+		t.Fatal("the unknown state must fail")
+	}
+}
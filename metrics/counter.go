@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Counter only goes up
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.v.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+// CounterVec is the family of the counters partitioned by the labels
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+	children   children[Counter]
+}
+
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labelNames: labelNames}
+}
+
+// the counter of the label values, given in the order of the label names
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	return v.children.with(func() *Counter { return &Counter{} }, labelValues)
+}
+
+func (v *CounterVec) Collect(w *Writer) {
+	w.Header(v.name, v.help, "counter")
+	for _, ch := range v.children.sorted() {
+		w.Sample(v.name, float64(ch.metric.Value()), labels(v.labelNames, ch.labelValues)...)
+	}
+}
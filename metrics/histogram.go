@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the default buckets of the latency in seconds, from 0.5ms to 5s
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Histogram counts the observations in the buckets,
+// the zero value uses DefBuckets
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64 // upper bounds of the buckets, increasing
+	counts []uint64  // observations of every bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	h := &Histogram{}
+	h.init(bounds)
+	return h
+}
+
+func (h *Histogram) init(bounds []float64) {
+	h.bounds = append([]float64(nil), bounds...)
+	sort.Float64s(h.bounds)
+	h.counts = make([]uint64, len(h.bounds)+1)
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.init(DefBuckets)
+	}
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+// observe the seconds since start
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// the cumulative counts of the buckets
+func (h *Histogram) snapshot() (bounds []float64, counts []uint64, sum float64, count uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.init(DefBuckets)
+	}
+	counts = make([]uint64, len(h.bounds))
+	var cumulative uint64
+	for i := range h.bounds {
+		cumulative += h.counts[i]
+		counts[i] = cumulative
+	}
+	return h.bounds, counts, h.sum, h.count
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) Sum() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+// HistogramVec is the family of the histograms partitioned by the labels
+type HistogramVec struct {
+	name       string
+	help       string
+	bounds     []float64
+	labelNames []string
+	children   children[Histogram]
+}
+
+// bounds: nil means DefBuckets
+func NewHistogramVec(name string, help string, bounds []float64, labelNames ...string) *HistogramVec {
+	if bounds == nil {
+		bounds = DefBuckets
+	}
+	return &HistogramVec{name: name, help: help, bounds: bounds, labelNames: labelNames}
+}
+
+// the histogram of the label values, given in the order of the label names
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	return v.children.with(func() *Histogram { return NewHistogram(v.bounds) }, labelValues)
+}
+
+func (v *HistogramVec) Collect(w *Writer) {
+	w.Header(v.name, v.help, "histogram")
+	for _, ch := range v.children.sorted() {
+		w.Histogram(v.name, ch.metric, labels(v.labelNames, ch.labelValues)...)
+	}
+}
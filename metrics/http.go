@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// statusRecorder remembers the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// InstrumentHandler counts the requests by the method and the status code,
+// and observes their latency by the method, the metrics are registered in r
+func InstrumentHandler(r *Registry, next http.Handler) http.Handler {
+	requests := NewCounterVec("http_requests_total", "HTTP requests by method and status code.", "method", "code")
+	duration := NewHistogramVec("http_request_duration_seconds", "HTTP request latency by method.", nil, "method")
+	r.Register(requests, duration)
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: resp, code: http.StatusOK}
+		next.ServeHTTP(recorder, req)
+		requests.With(req.Method, strconv.Itoa(recorder.code)).Inc()
+		duration.With(req.Method).ObserveSince(start)
+	})
+}
@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// content type of the prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector writes its metric families on every scrape
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// CollectorFunc computes the samples at the scrape time
+type CollectorFunc func(w *Writer)
+
+func (f CollectorFunc) Collect(w *Writer) {
+	f(w)
+}
+
+// Registry exposes the registered collectors in the prometheus text format
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds the collectors, the metric names must be unique in the registry
+func (r *Registry) Register(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// write all metric families in the registration order
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.mu.RLock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.RUnlock()
+	var buf bytes.Buffer
+	w := &Writer{w: bufio.NewWriter(&buf)}
+	for _, c := range collectors {
+		c.Collect(w)
+	}
+	w.w.Flush()
+	return buf.WriteTo(out)
+}
+
+func (r *Registry) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	resp.Header().Set("Content-Type", ContentType)
+	r.WriteTo(resp)
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// the labels of the names and the values in order
+func labels(names []string, values []string) []Label {
+	ls := make([]Label, len(names))
+	for i, name := range names {
+		ls[i] = Label{Name: name, Value: values[i]}
+	}
+	return ls
+}
+
+// Writer writes the samples in the prometheus text format,
+// the samples of a family must follow its header
+type Writer struct {
+	w *bufio.Writer
+}
+
+// typ: counter, gauge or histogram
+func (w *Writer) Header(name string, help string, typ string) {
+	w.w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func (w *Writer) Sample(name string, value float64, labels ...Label) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(l.Name + `="` + escapeLabel(l.Value) + `"`)
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// the buckets, sum and count samples of the histogram
+func (w *Writer) Histogram(name string, h *Histogram, labels ...Label) {
+	bounds, counts, sum, count := h.snapshot()
+	bucketLabels := append(append([]Label(nil), labels...), Label{Name: "le"})
+	for i, bound := range bounds {
+		bucketLabels[len(labels)].Value = formatFloat(bound)
+		w.Sample(name+"_bucket", float64(counts[i]), bucketLabels...)
+	}
+	bucketLabels[len(labels)].Value = "+Inf"
+	w.Sample(name+"_bucket", float64(count), bucketLabels...)
+	w.Sample(name+"_sum", sum, labels...)
+	w.Sample(name+"_count", float64(count), labels...)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// the children of a vector keyed by the label values
+type children[T any] struct {
+	mu     sync.RWMutex
+	values map[string]*child[T]
+}
+
+type child[T any] struct {
+	labelValues []string
+	metric      *T
+}
+
+func (c *children[T]) with(newMetric func() *T, labelValues []string) *T {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.RLock()
+	ch, ok := c.values[key]
+	c.mu.RUnlock()
+	if ok {
+		return ch.metric
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch, ok := c.values[key]; ok {
+		return ch.metric
+	}
+	if c.values == nil {
+		c.values = make(map[string]*child[T])
+	}
+	ch = &child[T]{labelValues: append([]string(nil), labelValues...), metric: newMetric()}
+	c.values[key] = ch
+	return ch.metric
+}
+
+// the children sorted by the label values, so the output is stable
+func (c *children[T]) sorted() []*child[T] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sorted := make([]*child[T], len(keys))
+	for i, key := range keys {
+		sorted[i] = c.values[key]
+	}
+	return sorted
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	var buf strings.Builder
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	requests := NewCounterVec("requests_total", "Requests.\nby code", "code", "path")
+	r.Register(requests)
+	requests.With("200", "/a").Inc()
+	requests.With("200", "/a").Add(2)
+	requests.With("500", `/"b"`).Inc()
+	expected := `# HELP requests_total Requests.\nby code
+# TYPE requests_total counter
+requests_total{code="200",path="/a"} 3
+requests_total{code="500",path="/\"b\""} 1
+`
+	if out := scrape(t, r); out != expected {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("the wrong label count must panic")
+		}
+	}()
+	requests.With("200")
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	latency := NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "op")
+	r.Register(latency)
+	latency.With("get").Observe(0.05)
+	latency.With("get").Observe(0.1)
+	latency.With("get").Observe(0.5)
+	latency.With("get").Observe(2)
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.1"} 2
+latency_seconds_bucket{op="get",le="1"} 3
+latency_seconds_bucket{op="get",le="+Inf"} 4
+latency_seconds_sum{op="get"} 2.65
+latency_seconds_count{op="get"} 4
+`
+	if out := scrape(t, r); out != expected {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	// the zero value uses the default buckets
+	var h Histogram
+	h.Observe(0.003)
+	if h.Count() != 1 || h.Sum() != 0.003 || len(h.counts) != len(DefBuckets)+1 {
+		t.Fail()
+	}
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Register(CollectorFunc(func(w *Writer) {
+		w.Header("up", "Up.", "gauge")
+		w.Sample("up", 1)
+	}))
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	server := httptest.NewServer(InstrumentHandler(r, mux))
+	defer server.Close()
+	for _, path := range []string{"/missing", "/missing", "/metrics"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != ContentType {
+		t.Fail()
+	}
+	out := scrape(t, r)
+	for _, line := range []string{
+		"up 1\n",
+		`http_requests_total{method="GET",code="200"} 2`,
+		`http_requests_total{method="GET",code="404"} 2`,
+		`http_request_duration_seconds_count{method="GET"} 4`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("%q not in the output:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// the raft handler must be mounted on the path of every member
+var DefaultPath = "/_raft/"
+
+const (
+	votePath   = "vote"
+	appendPath = "append"
+)
+
+type voteRequest struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type voteReply struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// the heartbeat is the append request without entries
+type appendRequest struct {
+	Term         uint64  `json:"term"`
+	Leader       string  `json:"leader"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+type appendReply struct {
+	Term      uint64 `json:"term"`
+	Success   bool   `json:"success"`
+	LastIndex uint64 `json:"last_index"` // the leader retries from here on failure
+}
+
+// ServeHTTP handles the vote and append requests from the other members
+func (n *Node) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	var reply any
+	switch strings.TrimPrefix(req.URL.Path, DefaultPath) {
+	case votePath:
+		var args voteRequest
+		if err := json.NewDecoder(req.Body).Decode(&args); err != nil {
+			http.Error(resp, "bad request body", http.StatusBadRequest)
+			return
+		}
+		reply = n.handleVote(args)
+	case appendPath:
+		var args appendRequest
+		if err := json.NewDecoder(req.Body).Decode(&args); err != nil {
+			http.Error(resp, "bad request body", http.StatusBadRequest)
+			return
+		}
+		reply = n.handleAppend(args)
+	default:
+		http.Error(resp, "unexpected path: "+req.URL.Path, http.StatusNotFound)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(reply)
+}
+
+func (n *Node) requestVote(peer string, args voteRequest) (voteReply, error) {
+	var reply voteReply
+	err := n.call(peer, votePath, args, &reply)
+	return reply, err
+}
+
+func (n *Node) appendEntries(peer string, args appendRequest) (appendReply, error) {
+	var reply appendReply
+	err := n.call(peer, appendPath, args, &reply)
+	return reply, err
+}
+
+func (n *Node) call(peer string, path string, args any, reply any) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	httpClient := http.Client{Timeout: n.config.RPCTimeout}
+	resp, err := httpClient.Post("http://"+peer+DefaultPath+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
@@ -0,0 +1,542 @@
+package raft
+
+import (
+	"distributed_cache/common"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// StateMachine applies the committed commands in the log order,
+// every node applies the same commands
+type StateMachine interface {
+	Apply(command []byte) error
+}
+
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return "unknown"
+	}
+}
+
+type Entry struct {
+	Term    uint64 `json:"term"`
+	Command []byte `json:"command"` // nil for the no-op entry of a new leader
+}
+
+type Config struct {
+	HeartbeatInterval time.Duration // the leader replicates the log per interval
+	ElectionTimeout   time.Duration // randomized in [timeout, 2 * timeout)
+	RPCTimeout        time.Duration
+	ProposeTimeout    time.Duration // max waiting time until the command is applied
+	// optional file of the term, the vote and the log, it is synced before
+	// the node replies to the RPCs, so the restarted node keeps its promises,
+	// without it the state is kept in memory only and the node is not crash-safe
+	StatePath string
+}
+
+var DefaultConfig = Config{
+	HeartbeatInterval: 50 * time.Millisecond,
+	ElectionTimeout:   300 * time.Millisecond,
+	RPCTimeout:        100 * time.Millisecond,
+	ProposeTimeout:    time.Second,
+}
+
+// the proposer waiting for the entry to be applied
+type waiter struct {
+	term uint64
+	done chan error
+}
+
+// Node is a member of the raft cluster, the state is kept in the state file
+// of the config, or in memory only if it is not given: then the restarted node
+// catches up from the leader, and refuses to vote for one election timeout
+// in case it voted in the current term before the restart
+type Node struct {
+	mu     sync.Mutex
+	self   string
+	peers  []string // the other members
+	config Config
+	sm     StateMachine
+
+	role        Role
+	term        uint64
+	votedFor    string
+	leader      string
+	log         []Entry // log[0] is the sentinel, the index of the entry is its position
+	commitIndex uint64
+	lastApplied uint64
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	inflight    map[string]bool // one append request per peer at a time
+	deadline    time.Time       // the election starts after the deadline
+	voteAfter   time.Time       // the votes are refused before it
+	dirty       bool            // the term, the vote or the log is not persisted
+	waiters     map[uint64]waiter
+	applyCond   *sync.Cond
+
+	stop    chan struct{}
+	stopped bool
+	once    sync.Once
+}
+
+// self and peers: addrs of the members, e.g. localhost:9999,
+// the raft handler must be mounted on every member
+func NewNode(self string, peers []string, sm StateMachine, config Config) *Node {
+	n := &Node{
+		self:       self,
+		config:     config,
+		sm:         sm,
+		log:        []Entry{{}},
+		nextIndex:  make(map[string]uint64),
+		matchIndex: make(map[string]uint64),
+		inflight:   make(map[string]bool),
+		waiters:    make(map[uint64]waiter),
+		stop:       make(chan struct{}),
+	}
+	for _, peer := range peers {
+		if peer != self {
+			n.peers = append(n.peers, peer)
+		}
+	}
+	n.applyCond = sync.NewCond(&n.mu)
+	return n
+}
+
+func (n *Node) logf(format string, v ...any) {
+	if common.DEBUG {
+		log.Printf(format, v...)
+	}
+}
+
+// Start runs the election timer and the apply loop until Stop is called
+func (n *Node) Start() error {
+	c := n.config
+	if c.HeartbeatInterval <= 0 || c.ElectionTimeout <= c.HeartbeatInterval || c.RPCTimeout <= 0 || c.ProposeTimeout <= 0 {
+		return common.ErrPositiveParamNegative
+	}
+	n.mu.Lock()
+	if c.StatePath != "" {
+		if err := n.restore(); err != nil {
+			n.mu.Unlock()
+			return err
+		}
+	} else {
+		n.voteAfter = time.Now().Add(c.ElectionTimeout)
+	}
+	n.resetDeadline()
+	n.mu.Unlock()
+	go n.run()
+	go n.applyLoop()
+	return nil
+}
+
+func (n *Node) Stop() {
+	n.once.Do(func() {
+		close(n.stop)
+		n.mu.Lock()
+		n.stopped = true
+		n.applyCond.Broadcast()
+		n.mu.Unlock()
+	})
+}
+
+func (n *Node) Self() string {
+	return n.self
+}
+
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == Leader
+}
+
+// the addr of the current leader, empty if unknown
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+func (n *Node) Role() Role {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role
+}
+
+func (n *Node) Term() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.term
+}
+
+func (n *Node) run() {
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.mu.Lock()
+			isLeader := n.role == Leader
+			timeout := !isLeader && time.Now().After(n.deadline)
+			n.mu.Unlock()
+			if isLeader {
+				n.broadcast()
+			} else if timeout {
+				n.startElection()
+			}
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// must hold the lock
+func (n *Node) resetDeadline() {
+	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+// must hold the lock
+func (n *Node) lastIndex() uint64 {
+	return uint64(len(n.log) - 1)
+}
+
+// must hold the lock
+func (n *Node) becomeFollower(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.dirty = true
+	}
+	if n.role != Follower {
+		n.logf("raft-%s: %s -> follower in term %d", n.self, n.role, n.term)
+	}
+	n.role = Follower
+}
+
+// must hold the lock
+func (n *Node) becomeLeader() {
+	n.logf("raft-%s: leader in term %d", n.self, n.term)
+	n.role = Leader
+	n.leader = n.self
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+	// the entries of the previous terms are committed along with the no-op entry
+	n.log = append(n.log, Entry{Term: n.term})
+	n.dirty = true
+	if err := n.persist(); err != nil {
+		// the no-op entry is not counted until it is persisted
+		n.logf("raft-%s: [ERROR] persist the state: %v", n.self, err)
+		return
+	}
+	n.advanceCommit()
+}
+
+func (n *Node) majority() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+func (n *Node) startElection() {
+	n.mu.Lock()
+	n.role = Candidate
+	n.term++
+	n.votedFor = n.self
+	n.dirty = true
+	n.leader = ""
+	n.resetDeadline()
+	// the vote for itself must not be forgotten
+	if err := n.persist(); err != nil {
+		n.logf("raft-%s: [ERROR] persist the state: %v", n.self, err)
+		n.mu.Unlock()
+		return
+	}
+	term := n.term
+	args := voteRequest{
+		Term:         term,
+		Candidate:    n.self,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.log[n.lastIndex()].Term,
+	}
+	n.logf("raft-%s: start election in term %d", n.self, term)
+	votes := 1
+	if votes >= n.majority() {
+		n.becomeLeader()
+	}
+	n.mu.Unlock()
+
+	for _, peer := range n.peers {
+		go func(peer string) {
+			reply, err := n.requestVote(peer, args)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.term {
+				n.becomeFollower(reply.Term)
+				n.save()
+				return
+			}
+			if n.role != Candidate || n.term != term || !reply.Granted {
+				return
+			}
+			votes++
+			if votes >= n.majority() {
+				n.becomeLeader()
+				go n.broadcast()
+			}
+		}(peer)
+	}
+}
+
+// replicate the log to all peers
+func (n *Node) broadcast() {
+	for _, peer := range n.peers {
+		go n.replicate(peer)
+	}
+}
+
+func (n *Node) replicate(peer string) {
+	n.mu.Lock()
+	if n.role != Leader || n.inflight[peer] {
+		n.mu.Unlock()
+		return
+	}
+	n.inflight[peer] = true
+	term := n.term
+	prev := n.nextIndex[peer] - 1
+	args := appendRequest{
+		Term:         term,
+		Leader:       n.self,
+		PrevLogIndex: prev,
+		PrevLogTerm:  n.log[prev].Term,
+		Entries:      append([]Entry(nil), n.log[prev+1:]...),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	reply, err := n.appendEntries(peer, args)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.inflight[peer] = false
+	if err != nil {
+		return
+	}
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term)
+		n.save()
+		return
+	}
+	if n.role != Leader || n.term != term {
+		return
+	}
+	if !reply.Success {
+		// skip back to the end of the peer's log at once
+		next := n.nextIndex[peer] - 1
+		if reply.LastIndex+1 < next {
+			next = reply.LastIndex + 1
+		}
+		n.nextIndex[peer] = max(next, 1)
+		return
+	}
+	match := prev + uint64(len(args.Entries))
+	if match > n.matchIndex[peer] {
+		n.matchIndex[peer] = match
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommit()
+}
+
+// commit the latest entry of the current term stored on the majority, must hold the lock
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.log[index].Term != n.term {
+			return
+		}
+		count := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.majority() {
+			n.commitIndex = index
+			n.applyCond.Broadcast()
+			return
+		}
+	}
+}
+
+// apply the committed entries in order, the state machine is called without the lock
+func (n *Node) applyLoop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for {
+		for !n.stopped && n.lastApplied >= n.commitIndex {
+			n.applyCond.Wait()
+		}
+		if n.stopped {
+			return
+		}
+		entries := append([]Entry(nil), n.log[n.lastApplied+1:n.commitIndex+1]...)
+		first := n.lastApplied + 1
+		n.mu.Unlock()
+		for i, entry := range entries {
+			var err error
+			if entry.Command != nil {
+				err = n.sm.Apply(entry.Command)
+			}
+			index := first + uint64(i)
+			n.mu.Lock()
+			n.lastApplied = index
+			if w, ok := n.waiters[index]; ok {
+				delete(n.waiters, index)
+				// the entry of the proposer was overwritten by another leader
+				if w.term != entry.Term {
+					err = common.ErrNotLeader
+				}
+				w.done <- err
+			}
+			n.mu.Unlock()
+		}
+		n.mu.Lock()
+	}
+}
+
+// Propose appends the command to the log on the leader,
+// returns the result of the state machine once the command is applied
+func (n *Node) Propose(command []byte) error {
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return common.ErrNotLeader
+	}
+	n.log = append(n.log, Entry{Term: n.term, Command: command})
+	n.dirty = true
+	index := n.lastIndex()
+	if err := n.persist(); err != nil {
+		n.log = n.log[:index]
+		n.mu.Unlock()
+		return err
+	}
+	done := make(chan error, 1)
+	n.waiters[index] = waiter{term: n.term, done: done}
+	// the single member cluster commits at once
+	n.advanceCommit()
+	n.mu.Unlock()
+	n.broadcast()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(n.config.ProposeTimeout):
+		n.mu.Lock()
+		delete(n.waiters, index)
+		n.mu.Unlock()
+		return common.ErrTimeout
+	case <-n.stop:
+		return common.ErrTimeout
+	}
+}
+
+// persist the state, the error is logged only, must hold the lock
+func (n *Node) save() {
+	if err := n.persist(); err != nil {
+		n.logf("raft-%s: [ERROR] persist the state: %v", n.self, err)
+	}
+}
+
+func (n *Node) handleVote(args voteRequest) voteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// the restarted node may have voted in this term already
+	if time.Now().Before(n.voteAfter) {
+		return voteReply{Term: n.term}
+	}
+	if args.Term > n.term {
+		n.becomeFollower(args.Term)
+		n.save()
+	}
+	reply := voteReply{Term: n.term}
+	if args.Term < n.term || (n.votedFor != "" && n.votedFor != args.Candidate) {
+		return reply
+	}
+	// the candidate's log must be at least as up-to-date as ours
+	lastTerm := n.log[n.lastIndex()].Term
+	if args.LastLogTerm < lastTerm || (args.LastLogTerm == lastTerm && args.LastLogIndex < n.lastIndex()) {
+		return reply
+	}
+	n.votedFor = args.Candidate
+	n.dirty = true
+	if err := n.persist(); err != nil {
+		n.logf("raft-%s: [ERROR] persist the state: %v", n.self, err)
+		n.votedFor = ""
+		return reply
+	}
+	n.resetDeadline()
+	reply.Granted = true
+	return reply
+}
+
+func (n *Node) handleAppend(args appendRequest) appendReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if args.Term < n.term {
+		return appendReply{Term: n.term, LastIndex: n.lastIndex()}
+	}
+	n.becomeFollower(args.Term)
+	n.save()
+	n.leader = args.Leader
+	n.resetDeadline()
+	reply := appendReply{Term: n.term}
+	if args.PrevLogIndex > n.lastIndex() || n.log[args.PrevLogIndex].Term != args.PrevLogTerm {
+		reply.LastIndex = min(n.lastIndex(), args.PrevLogIndex-1)
+		return reply
+	}
+	for i, entry := range args.Entries {
+		index := args.PrevLogIndex + 1 + uint64(i)
+		if index <= n.lastIndex() {
+			if n.log[index].Term == entry.Term {
+				continue
+			}
+			// drop the conflicting entry and all that follow it
+			n.log = n.log[:index]
+		}
+		n.log = append(n.log, args.Entries[i:]...)
+		n.dirty = true
+		break
+	}
+	// the entries are acknowledged once they are persisted
+	if err := n.persist(); err != nil {
+		n.logf("raft-%s: [ERROR] persist the state: %v", n.self, err)
+		reply.LastIndex = min(n.lastIndex(), args.PrevLogIndex)
+		return reply
+	}
+	if commit := min(args.LeaderCommit, args.PrevLogIndex+uint64(len(args.Entries))); commit > n.commitIndex {
+		n.commitIndex = commit
+		n.applyCond.Broadcast()
+	}
+	reply.Success = true
+	reply.LastIndex = n.lastIndex()
+	return reply
+}
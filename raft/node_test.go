@@ -0,0 +1,258 @@
+package raft
+
+import (
+	"distributed_cache/common"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	HeartbeatInterval: 10 * time.Millisecond,
+	ElectionTimeout:   50 * time.Millisecond,
+	RPCTimeout:        30 * time.Millisecond,
+	ProposeTimeout:    time.Second,
+}
+
+// records the applied commands
+type recorder struct {
+	sync.Mutex
+	commands []string
+}
+
+func (r *recorder) Apply(command []byte) error {
+	r.Lock()
+	defer r.Unlock()
+	r.commands = append(r.commands, string(command))
+	return nil
+}
+
+func (r *recorder) applied() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+type testMember struct {
+	*Node
+	sm     *recorder
+	server *httptest.Server
+}
+
+func (m *testMember) close() {
+	m.Stop()
+	m.server.Close()
+}
+
+func newTestCluster(t *testing.T, size int) []*testMember {
+	var servers []*httptest.Server
+	var addrs []string
+	for i := 0; i < size; i++ {
+		server := httptest.NewUnstartedServer(nil)
+		servers = append(servers, server)
+		addrs = append(addrs, server.Listener.Addr().String())
+	}
+	var members []*testMember
+	for i, server := range servers {
+		sm := &recorder{}
+		node := NewNode(addrs[i], addrs, sm, testConfig)
+		server.Config.Handler = node
+		server.Start()
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, &testMember{Node: node, sm: sm, server: server})
+	}
+	return members
+}
+
+// the only leader of the members
+func waitLeader(members []*testMember) *testMember {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []*testMember
+		for _, m := range members {
+			if m.IsLeader() {
+				leaders = append(leaders, m)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func waitApplied(members []*testMember, want []string) bool {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		done := true
+		for _, m := range members {
+			if !reflect.DeepEqual(m.sm.applied(), want) {
+				done = false
+			}
+		}
+		if done {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestSingleMember(t *testing.T) {
+	members := newTestCluster(t, 1)
+	defer members[0].close()
+	if waitLeader(members) == nil {
+		t.Fatal("the single member must be the leader")
+	}
+	if err := members[0].Propose([]byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := members[0].sm.applied(); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Fatalf("applied %v", got)
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	members := newTestCluster(t, 3)
+	for _, m := range members {
+		defer m.close()
+	}
+	leader := waitLeader(members)
+	if leader == nil {
+		t.Fatal("no leader elected")
+	}
+	for _, m := range members {
+		if m != leader {
+			if err := m.Propose([]byte("x")); err != common.ErrNotLeader {
+				t.Fatal("the follower must reject the proposal")
+			}
+		}
+	}
+	for _, command := range []string{"1", "2", "3"} {
+		if err := leader.Propose([]byte(command)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !waitApplied(members, []string{"1", "2", "3"}) {
+		t.Fatal("all members must apply the same commands")
+	}
+
+	// the remaining majority elects a new leader which keeps the committed commands
+	leader.close()
+	var rest []*testMember
+	for _, m := range members {
+		if m != leader {
+			rest = append(rest, m)
+		}
+	}
+	newLeader := waitLeader(rest)
+	if newLeader == nil {
+		t.Fatal("no new leader elected")
+	}
+	if err := newLeader.Propose([]byte("4")); err != nil {
+		t.Fatal(err)
+	}
+	if !waitApplied(rest, []string{"1", "2", "3", "4"}) {
+		t.Fatal("the new leader must keep the committed commands")
+	}
+	for _, m := range rest {
+		if m.Leader() != newLeader.Self() {
+			t.Fatalf("%s follows %s, but the leader is %s", m.Self(), m.Leader(), newLeader.Self())
+		}
+	}
+}
+
+func TestVote(t *testing.T) {
+	node := NewNode("a", []string{"a", "b", "c"}, &recorder{}, testConfig)
+	node.log = append(node.log, Entry{Term: 2})
+	node.term = 2
+	// the candidate with the stale log is rejected
+	if reply := node.handleVote(voteRequest{Term: 3, Candidate: "b", LastLogIndex: 5, LastLogTerm: 1}); reply.Granted {
+		t.Fatal("the stale candidate must be rejected")
+	}
+	if reply := node.handleVote(voteRequest{Term: 3, Candidate: "c", LastLogIndex: 1, LastLogTerm: 2}); !reply.Granted || reply.Term != 3 {
+		t.Fatal("the up-to-date candidate must be granted")
+	}
+	// one vote per term
+	if reply := node.handleVote(voteRequest{Term: 3, Candidate: "b", LastLogIndex: 1, LastLogTerm: 2}); reply.Granted {
+		t.Fatal("voted twice in one term")
+	}
+}
+
+func TestAppendConflict(t *testing.T) {
+	node := NewNode("a", []string{"a", "b"}, &recorder{}, testConfig)
+	node.log = append(node.log, Entry{Term: 1}, Entry{Term: 1, Command: []byte("stale")})
+	node.term = 1
+	reply := node.handleAppend(appendRequest{
+		Term:         2,
+		Leader:       "b",
+		PrevLogIndex: 1,
+		PrevLogTerm:  1,
+		Entries:      []Entry{{Term: 2, Command: []byte("new")}},
+	})
+	if !reply.Success || reply.LastIndex != 2 || string(node.log[2].Command) != "new" {
+		t.Fatal("the conflicting entry must be replaced")
+	}
+	// the missing entries are reported
+	reply = node.handleAppend(appendRequest{Term: 2, Leader: "b", PrevLogIndex: 5, PrevLogTerm: 2})
+	if reply.Success || reply.LastIndex != 2 {
+		t.Fatal("the gap must be rejected")
+	}
+	if node.Leader() != "b" {
+		t.Fail()
+	}
+}
+
+func TestPersistState(t *testing.T) {
+	config := testConfig
+	config.StatePath = filepath.Join(t.TempDir(), "raft.json")
+	node := NewNode("a", []string{"a", "b", "c"}, &recorder{}, config)
+	if reply := node.handleVote(voteRequest{Term: 3, Candidate: "c"}); !reply.Granted {
+		t.Fatal("the candidate must be granted")
+	}
+	reply := node.handleAppend(appendRequest{
+		Term:    3,
+		Leader:  "c",
+		Entries: []Entry{{Term: 3, Command: []byte("1")}},
+	})
+	if !reply.Success {
+		t.Fatal("the entries must be appended")
+	}
+
+	// the restarted node keeps its vote and log
+	restarted := NewNode("a", []string{"a", "b", "c"}, &recorder{}, config)
+	if err := restarted.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Stop()
+	if reply := restarted.handleVote(voteRequest{Term: 3, Candidate: "b", LastLogIndex: 1, LastLogTerm: 3}); reply.Granted {
+		t.Fatal("voted twice in one term after the restart")
+	}
+	restarted.mu.Lock()
+	defer restarted.mu.Unlock()
+	if restarted.term != 3 || restarted.votedFor != "c" || restarted.lastIndex() != 1 || string(restarted.log[1].Command) != "1" {
+		t.Fatalf("the state is not restored: term %d vote %s log %v", restarted.term, restarted.votedFor, restarted.log)
+	}
+}
+
+func TestRestartWithoutState(t *testing.T) {
+	node := NewNode("a", []string{"a", "b", "c"}, &recorder{}, testConfig)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+	// the node may have voted in the term before the restart
+	if reply := node.handleVote(voteRequest{Term: 1, Candidate: "b"}); reply.Granted {
+		t.Fatal("the vote must be refused right after the start")
+	}
+	time.Sleep(testConfig.ElectionTimeout)
+	if reply := node.handleVote(voteRequest{Term: 100, Candidate: "b"}); !reply.Granted {
+		t.Fatal("the vote must be granted after one election timeout")
+	}
+}
@@ -0,0 +1,70 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// the persistent state of the node, the sentinel entry is not stored
+type persistentState struct {
+	Term     uint64  `json:"term"`
+	VotedFor string  `json:"voted_for"`
+	Log      []Entry `json:"log"`
+}
+
+// load the state file, the missing file means a new node, must hold the lock
+func (n *Node) restore() error {
+	data, err := os.ReadFile(n.config.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state persistentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	n.term = state.Term
+	n.votedFor = state.VotedFor
+	n.log = append([]Entry{{}}, state.Log...)
+	return nil
+}
+
+// write the term, the vote and the log to the state file if they changed,
+// it is called before the node replies or acts on them, must hold the lock
+func (n *Node) persist() error {
+	if n.config.StatePath == "" || !n.dirty {
+		return nil
+	}
+	data, err := json.Marshal(persistentState{Term: n.term, VotedFor: n.votedFor, Log: n.log[1:]})
+	if err != nil {
+		return err
+	}
+	// replace the file at once, so the crash leaves either the old or the new state
+	tmp := n.config.StatePath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, n.config.StatePath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// the rename is durable once the directory is synced
+	if dir, err := os.Open(filepath.Dir(n.config.StatePath)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	n.dirty = false
+	return nil
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"distributed_cache/client"
+	"distributed_cache/service"
+	"encoding/json"
+	"net/http"
+)
+
+func (h *HTTPPool) serveGetMulti(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	var multi client.GetMultiRequest
+	if err := json.NewDecoder(req.Body).Decode(&multi); err != nil {
+		h.log("server-%s [ERROR]: %s", h.self, err.Error())
+		http.Error(resp, "bad request body", http.StatusBadRequest)
+		return
+	}
+	svc, err := service.GetService(multi.Service)
+	if err != nil {
+		http.Error(resp, "no such service: "+multi.Service, http.StatusNotFound)
+		return
+	}
+	h.log("server-%s [GET_MULTI]: service[%s] %d keys", h.self, multi.Service, len(multi.Keys))
+	ctx, cancel := requestContext(req)
+	defer cancel()
+	values, err := svc.GetMultiContext(ctx, multi.Keys)
+	result := client.GetMultiResult{Values: values}
+	if err != nil {
+		h.log("server-%s [ERROR]: %s", h.self, err.Error())
+		result.Error = err.Error()
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(result)
+}
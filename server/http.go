@@ -1,12 +1,22 @@
 package server
 
 import (
+	"context"
+	"distributed_cache/client"
 	"distributed_cache/common"
+	"distributed_cache/consistenthash"
+	"distributed_cache/metrics"
 	"distributed_cache/service"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var DefaultServiceName = "/_Cache/"
@@ -14,13 +24,24 @@ var DefaultServiceName = "/_Cache/"
 type HTTPPool struct {
 	self     string
 	basePath string
+
+	mu      sync.RWMutex
+	peers   *consistenthash.Map       // nil unless the peer-to-peer mode is enabled
+	clients map[string]*client.Client // peer addr to its client
+
+	metrics *metrics.Registry
+	handler http.Handler // serve with the request metrics
 }
 
 func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
+	h := &HTTPPool{
 		self:     self,
 		basePath: DefaultServiceName,
+		metrics:  metrics.NewRegistry(),
 	}
+	h.metrics.Register(service.Collectors()...)
+	h.handler = metrics.InstrumentHandler(h.metrics, http.HandlerFunc(h.serve))
+	return h
 }
 
 func (h *HTTPPool) log(format string, v ...any) {
@@ -30,7 +51,31 @@ func (h *HTTPPool) log(format string, v ...any) {
 }
 
 func (h *HTTPPool) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	h.handler.ServeHTTP(resp, req)
+}
+
+func (h *HTTPPool) serve(resp http.ResponseWriter, req *http.Request) {
 	// TODO: generate error message based on the error
+	if req.URL.Path == common.HealthPath {
+		resp.Write([]byte("ok"))
+		return
+	}
+	if req.URL.Path == common.MigratePath {
+		h.serveMigrate(resp, req)
+		return
+	}
+	if req.URL.Path == common.BatchPath {
+		h.serveGetMulti(resp, req)
+		return
+	}
+	if req.URL.Path == common.StatsPath {
+		h.serveStats(resp, req)
+		return
+	}
+	if req.URL.Path == common.MetricsPath {
+		h.metrics.ServeHTTP(resp, req)
+		return
+	}
 	if !strings.HasPrefix(req.URL.Path, h.basePath) {
 		msg := fmt.Sprintf("HTTPPool server unexpected path: %s", req.URL.Path)
 		h.log("server-%s [ERROR]: HTTPPool server unexpected path: %s", h.self, req.URL.Path)
@@ -45,18 +90,129 @@ func (h *HTTPPool) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 	serviceName, key := parttens[0], parttens[1]
-	service, err := service.GetService(serviceName)
+	svc, err := service.GetService(serviceName)
 	if err != nil {
 		h.log("server-%s [ERROR]: %s", h.self, err.Error())
 		http.Error(resp, "no such service: "+serviceName, http.StatusNotFound)
 		return
 	}
+	switch req.Method {
+	case http.MethodGet:
+		h.serveGet(resp, req, svc, serviceName, key)
+	case http.MethodPut:
+		h.servePut(resp, req, svc, serviceName, key)
+	case http.MethodDelete:
+		h.serveDelete(resp, req, svc, serviceName, key)
+	default:
+		h.log("server-%s [ERROR]: method not allowed: %s", h.self, req.Method)
+		http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+// the stats of all services, or of the service given by the name query
+func (h *HTTPPool) serveStats(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	var stats any
+	if serviceName := req.URL.Query().Get("name"); serviceName != "" {
+		svc, err := service.GetService(serviceName)
+		if err != nil {
+			http.Error(resp, "no such service: "+serviceName, http.StatusNotFound)
+			return
+		}
+		stats = svc.Stats()
+	} else {
+		all := make(map[string]service.Stats)
+		for _, svc := range service.Services() {
+			all[svc.Name()] = svc.Stats()
+		}
+		stats = all
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(stats)
+}
+
+// the context of the request bounded by the budget in the deadline header,
+// the malformed header is ignored, the request forwarded by a peer is served locally
+func requestContext(req *http.Request) (context.Context, context.CancelFunc) {
+	ctx := req.Context()
+	if req.Header.Get(common.ForwardedHeader) != "" {
+		ctx = service.Forwarded(ctx)
+	}
+	budget, err := strconv.ParseInt(req.Header.Get(common.DeadlineHeader), 10, 64)
+	if err != nil {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(budget)*time.Millisecond)
+}
+
+func (h *HTTPPool) serveGet(resp http.ResponseWriter, req *http.Request, svc *service.Service, serviceName string, key string) {
 	h.log("server-%s [GET]: service[%s] key[%s]", h.self, serviceName, key)
-	value, err := service.Get(key)
+	ctx, cancel := requestContext(req)
+	defer cancel()
+	value, version, err := svc.GetVersionedContext(ctx, key)
+	if errors.Is(err, common.ErrKeyNotInDB) {
+		resp.Header().Set(common.NotFoundHeader, "1")
+		http.Error(resp, "key not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, common.ErrTimeout) {
+		http.Error(resp, err.Error(), http.StatusGatewayTimeout)
+		return
+	}
 	if err != nil {
 		h.log("server-%s [ERROR]: %s", h.self, err.Error())
 		http.Error(resp, "key not found", http.StatusInternalServerError)
 		return
 	}
+	resp.Header().Set(common.VersionHeader, strconv.FormatUint(version, 10))
 	resp.Write(value)
 }
+
+func (h *HTTPPool) servePut(resp http.ResponseWriter, req *http.Request, svc *service.Service, serviceName string, key string) {
+	h.log("server-%s [PUT]: service[%s] key[%s]", h.self, serviceName, key)
+	value, err := io.ReadAll(req.Body)
+	if err != nil {
+		h.log("server-%s [ERROR]: %s", h.self, err.Error())
+		http.Error(resp, "bad request body", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := requestContext(req)
+	defer cancel()
+	if header := req.Header.Get(common.VersionHeader); header != "" {
+		var version uint64
+		version, err = strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			http.Error(resp, "bad version: "+header, http.StatusBadRequest)
+			return
+		}
+		if req.Header.Get(common.CacheOnlyHeader) != "" {
+			err = svc.Populate(key, value, version)
+		} else {
+			err = svc.PutVersionedContext(ctx, key, value, version)
+		}
+	} else {
+		err = svc.PutContext(ctx, key, value)
+	}
+	if err != nil {
+		h.log("server-%s [ERROR]: %s", h.self, err.Error())
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(http.StatusOK)
+}
+
+func (h *HTTPPool) serveDelete(resp http.ResponseWriter, req *http.Request, svc *service.Service, serviceName string, key string) {
+	h.log("server-%s [DELETE]: service[%s] key[%s]", h.self, serviceName, key)
+	ctx, cancel := requestContext(req)
+	defer cancel()
+	err := svc.DeleteContext(ctx, key)
+	if err != nil {
+		h.log("server-%s [ERROR]: %s", h.self, err.Error())
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(http.StatusOK)
+}
@@ -3,10 +3,19 @@ package server
 import (
 	"context"
 	"distributed_cache/cache"
+	"distributed_cache/client"
+	"distributed_cache/common"
+	"distributed_cache/consistenthash"
 	"distributed_cache/service"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -36,6 +45,7 @@ func TestNewServer(t *testing.T) {
 		}),
 		2<<10,
 		2,
+		0,
 	)
 	ctx, cancel := context.WithCancel(context.TODO())
 	defer cancel()
@@ -55,3 +65,352 @@ func TestNewServer(t *testing.T) {
 	server := NewHTTPPool(addr)
 	http.ListenAndServe(addr, server)
 }
+
+func newTestService(name string, db map[string]string) *service.Service {
+	return service.NewService(
+		name,
+		service.GetterFunc(
+			func(key string) ([]byte, error) {
+				value, ok := db[key]
+				if !ok {
+					msg := fmt.Sprintf("the key %s not in db", key)
+					return nil, errors.New(msg)
+				}
+				return []byte(value), nil
+			}),
+		service.PutterFunc(func(key string, value []byte) error {
+			db[key] = string(value)
+			return nil
+		}),
+		cache.NewValueFunc(func(b []byte) cache.Value {
+			return cache.NewByteView(b)
+		}),
+		2<<10,
+		2,
+		0,
+	)
+}
+
+func TestServeDelete(t *testing.T) {
+	newTestService("delete", map[string]string{"Tom": "630"})
+	server := httptest.NewServer(NewHTTPPool("localhost"))
+	defer server.Close()
+	url := server.URL + DefaultServiceName + "delete/Tom"
+
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, resp.Status)
+	}
+	resp.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodPost, url, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fail()
+	}
+	resp.Body.Close()
+}
+
+func TestServePut(t *testing.T) {
+	db := map[string]string{}
+	newTestService("put", db)
+	server := httptest.NewServer(NewHTTPPool("localhost"))
+	defer server.Close()
+	url := server.URL + DefaultServiceName + "put/Tom"
+
+	req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader("630"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, resp.Status)
+	}
+	resp.Body.Close()
+	if db["Tom"] != "630" {
+		t.Fail()
+	}
+
+	resp, err = http.Get(url)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, resp.Status)
+	}
+	defer resp.Body.Close()
+	if value, _ := io.ReadAll(resp.Body); string(value) != "630" {
+		t.Fail()
+	}
+}
+
+func TestServeGetNotFound(t *testing.T) {
+	service.NewService("not-found", service.GetterFunc(func(key string) ([]byte, error) {
+		return nil, common.ErrKeyNotInDB
+	}), service.PutterFunc(func(key string, value []byte) error {
+		return nil
+	}), cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	}), 2<<10, 2, 0)
+	server := httptest.NewServer(NewHTTPPool("localhost"))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + DefaultServiceName + "not-found/Tom")
+	if err != nil || resp.StatusCode != http.StatusNotFound || resp.Header.Get(common.NotFoundHeader) == "" {
+		t.Fatal(err, resp.Status)
+	}
+	resp.Body.Close()
+	c := client.NewClient(server.URL + DefaultServiceName)
+	if _, err := c.Get("not-found", "Tom"); err != common.ErrKeyNotInDB {
+		t.Fatal(err)
+	}
+	// the unknown service is not reported as the missing key
+	if _, err := c.Get("unknown", "Tom"); err == nil || err == common.ErrKeyNotInDB {
+		t.Fatal(err)
+	}
+}
+
+func TestServeGetDeadline(t *testing.T) {
+	budgets := make(chan time.Duration, 1)
+	service.NewService("deadline", service.GetterContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		deadline, _ := ctx.Deadline()
+		budgets <- time.Until(deadline)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}), service.PutterFunc(func(key string, value []byte) error {
+		return nil
+	}), cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	}), 2<<10, 2, 0, service.WithTimeout(300*time.Millisecond))
+	server := httptest.NewServer(NewHTTPPool("localhost"))
+	defer server.Close()
+
+	// the budget of the client bounds the wait on the node,
+	// the shared load is bounded by the timeout of the service
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := client.NewClient(server.URL + DefaultServiceName)
+	start := time.Now()
+	if _, err := c.GetContext(ctx, "deadline", "Tom"); err == nil || time.Since(start) > 200*time.Millisecond {
+		t.Fatal("the wait is not bounded", err, time.Since(start))
+	}
+	if budget := <-budgets; budget <= 0 || budget > 300*time.Millisecond {
+		t.Fatal(budget)
+	}
+
+	// the node reports the elapsed budget as 504
+	req, _ := http.NewRequest(http.MethodGet, server.URL+DefaultServiceName+"deadline/Sam", nil)
+	req.Header.Set(common.DeadlineHeader, "50")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatal(err, resp.Status)
+	}
+	resp.Body.Close()
+	<-budgets
+}
+
+func TestServeGetMulti(t *testing.T) {
+	newTestService("multi", map[string]string{"Tom": "630", "Sam": "567"})
+	server := httptest.NewServer(NewHTTPPool("localhost"))
+	defer server.Close()
+	c := client.NewClient(server.URL + DefaultServiceName)
+
+	values, err := c.GetMulti("multi", []string{"Tom", "Sam"})
+	if err != nil || string(values["Tom"]) != "630" || string(values["Sam"]) != "567" {
+		t.Fatal(err, values)
+	}
+	// the getter error is returned with the values found
+	values, err = c.GetMulti("multi", []string{"Tom", "Jack"})
+	if err == nil || len(values) != 1 {
+		t.Fatal(err, values)
+	}
+	if _, err := c.GetMulti("unknown", []string{"Tom"}); err == nil {
+		t.Fatal("no such service")
+	}
+}
+
+func TestServeMigrate(t *testing.T) {
+	svc := newTestService("migrate", map[string]string{})
+	for i := 0; i < 10; i++ {
+		svc.Put(strconv.Itoa(i), []byte(strconv.Itoa(i)))
+	}
+	// the target node records the populated keys
+	var mu sync.Mutex
+	populated := make(map[string]string)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get(common.CacheOnlyHeader) == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		value, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		populated[strings.TrimPrefix(r.URL.Path, DefaultServiceName)] = string(value)
+		mu.Unlock()
+	}))
+	defer target.Close()
+	server := httptest.NewServer(NewHTTPPool("localhost"))
+	defer server.Close()
+
+	// migrate the keys whose hash is in the first half of the ring
+	half := consistenthash.Range{Start: 0, End: 1 << 31}
+	result, err := client.NewClient(server.URL + DefaultServiceName).Migrate(client.MigrateRequest{
+		Ranges:  []consistenthash.Range{half},
+		Targets: []string{target.URL + DefaultServiceName},
+		Evict:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := 0
+	for i := 0; i < 10; i++ {
+		key := strconv.Itoa(i)
+		_, _, inCache := svc.Peek(key)
+		if half.Contains(consistenthash.DefaultHash([]byte(key))) {
+			moved++
+			if populated["migrate/"+key] != key || inCache {
+				t.Fatalf("the key %s must be moved to the target", key)
+			}
+		} else if !inCache {
+			t.Fatalf("the key %s must stay in the cache", key)
+		}
+	}
+	// the entries of the other services are migrated too
+	if result.Moved != len(populated) || result.Moved < moved || result.Failed != 0 {
+		t.Fatalf("unexpected result %+v, %d keys should be moved", result, moved)
+	}
+
+	// the skipped service keeps its entries
+	whole := consistenthash.Range{Start: 0, End: 0}
+	_, err = client.NewClient(server.URL + DefaultServiceName).Migrate(client.MigrateRequest{
+		Ranges:   []consistenthash.Range{whole},
+		Targets:  []string{target.URL + DefaultServiceName},
+		Evict:    true,
+		Services: []string{"migrate", "score"},
+		Skip:     []string{"migrate"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Keys()) != 10-moved {
+		t.Fatalf("the skipped service must not be migrated, %d keys left", len(svc.Keys()))
+	}
+}
+
+func TestServeStats(t *testing.T) {
+	svc := newTestService("stats", map[string]string{"Tom": "630"})
+	svc.Get("Tom")
+	svc.Get("Tom")
+	server := httptest.NewServer(NewHTTPPool("localhost"))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + common.StatsPath + "?name=stats")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, resp.Status)
+	}
+	var stats service.Stats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	resp.Body.Close()
+	if err != nil || stats.Gets != 2 || stats.Loads != 1 || stats.Cache.Hits != 1 {
+		t.Fatalf("unexpected stats %+v, %v", stats, err)
+	}
+
+	resp, err = http.Get(server.URL + common.StatsPath)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, resp.Status)
+	}
+	all := make(map[string]service.Stats)
+	err = json.NewDecoder(resp.Body).Decode(&all)
+	resp.Body.Close()
+	if _, ok := all["stats"]; err != nil || !ok {
+		t.Fail()
+	}
+
+	resp, err = http.Get(server.URL + common.StatsPath + "?name=none")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fail()
+	}
+	resp.Body.Close()
+}
+
+func TestServeMetrics(t *testing.T) {
+	svc := newTestService("metrics", map[string]string{"Tom": "630"})
+	svc.Get("Tom")
+	svc.Get("Tom")
+	server := httptest.NewServer(NewHTTPPool("localhost"))
+	defer server.Close()
+	resp, err := http.Get(server.URL + DefaultServiceName + "metrics/Jack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + common.MetricsPath)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, resp.Status)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, line := range []string{
+		`cache_hits_total{service="metrics"} 1`,
+		`service_loads_total{service="metrics"} 2`,
+		`service_load_errors_total{service="metrics"} 1`,
+		`cache_operation_duration_seconds_count{service="metrics",op="get"} 3`,
+		`service_load_duration_seconds_count{service="metrics"} 2`,
+		`http_requests_total{method="GET",code="500"} 1`,
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Fatalf("%q not in the metrics:\n%s", line, body)
+		}
+	}
+}
+
+// the picker whose ring says the key is owned by the peer, but the peer
+// thinks the other way, so every key is forwarded back to the node
+type loopPicker struct {
+	peer *client.Client
+}
+
+func (p loopPicker) PickPeer(key string) (service.PeerGetter, bool) {
+	return p.peer, true
+}
+
+func TestServeForwarded(t *testing.T) {
+	db := map[string]string{"Tom": "630"}
+	svc := newTestService("forwarded", db)
+	server := httptest.NewServer(NewHTTPPool("localhost"))
+	defer server.Close()
+	svc.RegisterPeers(loopPicker{peer: client.NewPeerClient(server.URL + DefaultServiceName)})
+
+	// the forwarded requests are served locally instead of being forwarded again
+	if value, err := svc.Get("Tom"); err != nil || string(value) != "630" {
+		t.Fatal(value, err)
+	}
+	if err := svc.Put("Sam", []byte("567")); err != nil || db["Sam"] != "567" {
+		t.Fatal(err, db)
+	}
+	if err := svc.Delete("Sam"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPickPeer(t *testing.T) {
+	pool := NewHTTPPool("localhost:8001")
+	if _, ok := pool.PickPeer("1"); ok {
+		t.Fatal("no peer is set")
+	}
+	if err := pool.Set("localhost:8001", "localhost:8002"); err != nil {
+		t.Fatal(err)
+	}
+	ring := consistenthash.NewMap(DefaultReplicas, nil)
+	ring.Add("localhost:8001", "localhost:8002")
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		owner, _ := ring.Search(key)
+		peer, ok := pool.PickPeer(key)
+		if ok != (owner != "localhost:8001") {
+			t.Fatalf("key %s is owned by %s", key, owner)
+		}
+		if ok && peer.(*client.Client).ServerAddr() != "http://localhost:8002"+DefaultServiceName {
+			t.Fail()
+		}
+		// the writes of the key are forwarded to the owner
+		if _, writer := peer.(service.PeerWriter); ok && !writer {
+			t.Fatal("the peer must accept the forwarded writes")
+		}
+	}
+}
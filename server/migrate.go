@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"distributed_cache/client"
+	"distributed_cache/consistenthash"
+	"distributed_cache/service"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// the key hash must be computed in the same way as the master,
+// the master must use the default hash function
+func inRanges(ranges []consistenthash.Range, key string) bool {
+	hashValue := consistenthash.DefaultHash([]byte(key))
+	for _, r := range ranges {
+		if r.Contains(hashValue) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HTTPPool) serveMigrate(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(resp, "method not allowed: "+req.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	var migrate client.MigrateRequest
+	if err := json.NewDecoder(req.Body).Decode(&migrate); err != nil {
+		h.log("server-%s [ERROR]: %s", h.self, err.Error())
+		http.Error(resp, "bad request body", http.StatusBadRequest)
+		return
+	}
+	h.log("server-%s [MIGRATE]: %d ranges to %v, evict %v", h.self, len(migrate.Ranges), migrate.Targets, migrate.Evict)
+	ctx, cancel := requestContext(req)
+	defer cancel()
+	result := h.migrate(ctx, migrate)
+	h.log("server-%s [MIGRATE]: moved %d, evicted %d, failed %d", h.self, result.Moved, result.Evicted, result.Failed)
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(result)
+}
+
+// copy the entries in the ranges of all services to the targets,
+// the entry is evicted only after it is copied to all the targets,
+// the entries left once the context is done stay on the node
+func (h *HTTPPool) migrate(ctx context.Context, migrate client.MigrateRequest) client.MigrateResult {
+	var result client.MigrateResult
+	targets := make([]*client.Client, len(migrate.Targets))
+	for i, target := range migrate.Targets {
+		targets[i] = client.NewClient(target)
+	}
+	var interval time.Duration
+	if migrate.Rate > 0 {
+		interval = time.Second / time.Duration(migrate.Rate)
+	}
+	for _, svc := range service.Services() {
+		if !migrate.Includes(svc.Name()) {
+			continue
+		}
+		for _, key := range svc.Keys() {
+			if ctx.Err() != nil {
+				return result
+			}
+			if !inRanges(migrate.Ranges, key) {
+				continue
+			}
+			value, version, ok := svc.Peek(key)
+			if !ok {
+				continue
+			}
+			failed := false
+			for _, target := range targets {
+				if err := target.PopulateContext(ctx, svc.Name(), key, value, version); err != nil {
+					failed = true
+				}
+			}
+			if failed {
+				result.Failed++
+				continue
+			}
+			if len(targets) > 0 {
+				result.Moved++
+			}
+			if migrate.Evict {
+				svc.Evict(key)
+				result.Evicted++
+			}
+			if interval > 0 {
+				time.Sleep(interval)
+			}
+		}
+	}
+	return result
+}
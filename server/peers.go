@@ -0,0 +1,43 @@
+package server
+
+import (
+	"distributed_cache/client"
+	"distributed_cache/consistenthash"
+	"distributed_cache/service"
+)
+
+// virtual peer num of the hash ring in the peer-to-peer mode
+var DefaultReplicas = 3
+
+// Set replaces the peers of the pool, used in the peer-to-peer mode,
+// the addrs must include the node itself and be the same on every node
+func (h *HTTPPool) Set(addrs ...string) error {
+	ring := consistenthash.NewMap(DefaultReplicas, nil)
+	if err := ring.Add(addrs...); err != nil {
+		return err
+	}
+	clients := make(map[string]*client.Client, len(addrs))
+	for _, addr := range addrs {
+		clients[addr] = client.NewPeerClient("http://" + addr + h.basePath)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.peers = ring
+	h.clients = clients
+	return nil
+}
+
+// PickPeer implements service.PeerPicker
+func (h *HTTPPool) PickPeer(key string) (service.PeerGetter, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.peers == nil {
+		return nil, false
+	}
+	addr, err := h.peers.Search(key)
+	if err != nil || addr == h.self {
+		return nil, false
+	}
+	h.log("server-%s [PICK]: key[%s] owned by %s", h.self, key, addr)
+	return h.clients[addr], true
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"distributed_cache/cache"
+	"sync"
+	"time"
+)
+
+// KeyEnumerator lists the keys in the db, the bloom filter is populated by it
+// on start and rebuilt by it periodically
+type KeyEnumerator interface {
+	Keys() ([]string, error)
+}
+
+type KeyEnumeratorFunc func() ([]string, error)
+
+func (f KeyEnumeratorFunc) Keys() ([]string, error) {
+	return f()
+}
+
+type bloomConfig struct {
+	keys              KeyEnumerator
+	expected          int
+	falsePositiveRate float64
+	rebuild           time.Duration // rebuild the filter from the keys every interval, <= 0 means never
+}
+
+// the bloom filter replaced by the rebuild,
+// the keys added during the rebuild go to both the filter and the next one
+type bloomFilter struct {
+	config bloomConfig
+
+	mu     sync.RWMutex
+	filter *cache.BloomFilter // nil if the keys can't be listed
+	next   *cache.BloomFilter // nil unless the filter is being rebuilt
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (s *Service) newBloomFilter(config bloomConfig) *bloomFilter {
+	// the config is checked before the keys are listed
+	if _, err := cache.NewBloomFilter(config.expected, config.falsePositiveRate); err != nil {
+		panic(err)
+	}
+	b := &bloomFilter{config: config}
+	s.rebuildBloomFilter(b)
+	if config.rebuild > 0 {
+		b.stop = make(chan struct{})
+		b.done = make(chan struct{})
+		go s.runBloomRebuild(b)
+	}
+	return b
+}
+
+// fill a new filter with the keys listed by the enumerator and replace the old one,
+// the old one is kept if the keys can't be listed, the filter is disabled if there is no old one,
+// otherwise the existing keys would be rejected
+func (s *Service) rebuildBloomFilter(b *bloomFilter) {
+	next, _ := cache.NewBloomFilter(b.config.expected, b.config.falsePositiveRate)
+	b.mu.Lock()
+	b.next = next
+	b.mu.Unlock()
+	// the write pending before the next filter is set is not in it, it is either
+	// still pending here or already flushed to the db before the keys are listed
+	var pending []string
+	if s.writeBehind != nil {
+		pending = s.writeBehind.keys()
+	}
+	keys, err := b.config.keys.Keys()
+	if err == nil {
+		keys = append(keys, pending...)
+		// the Gets are not blocked by the fill
+		for _, key := range keys {
+			next.Add(key)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next = nil
+	if err != nil {
+		s.log("service-%s: [ERROR] the bloom filter is not rebuilt, err: %v", s.name, err)
+		return
+	}
+	b.filter = next
+}
+
+func (s *Service) runBloomRebuild(b *bloomFilter) {
+	defer close(b.done)
+	ticker := time.NewTicker(b.config.rebuild)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			s.rebuildBloomFilter(b)
+		}
+	}
+}
+
+func (b *bloomFilter) close() {
+	if b.stop != nil {
+		close(b.stop)
+		<-b.done
+	}
+}
+
+// whether the key is definitely not in the db
+func (s *Service) rejected(key string) bool {
+	if s.bloom == nil {
+		return false
+	}
+	s.bloom.mu.RLock()
+	defer s.bloom.mu.RUnlock()
+	return s.bloom.filter != nil && !s.bloom.filter.MayContain(key)
+}
+
+// the key is written, migrated or loaded, so it is in the db
+func (s *Service) addKey(key string) {
+	if s.bloom == nil {
+		return
+	}
+	s.bloom.mu.RLock()
+	defer s.bloom.mu.RUnlock()
+	if s.bloom.filter != nil {
+		s.bloom.filter.Add(key)
+	}
+	if s.bloom.next != nil {
+		s.bloom.next.Add(key)
+	}
+}
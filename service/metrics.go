@@ -0,0 +1,61 @@
+package service
+
+import (
+	"distributed_cache/metrics"
+	"sort"
+)
+
+var (
+	opDuration   = metrics.NewHistogramVec("cache_operation_duration_seconds", "Latency of the service operations.", nil, "service", "op")
+	loadDuration = metrics.NewHistogramVec("service_load_duration_seconds", "Latency of the getter loads.", nil, "service")
+)
+
+// the families computed from Stats at the scrape time
+var statsFamilies = []struct {
+	name  string
+	help  string
+	typ   string
+	value func(s Stats) float64
+}{
+	{"cache_hits_total", "Cache hits.", "counter", func(s Stats) float64 { return float64(s.Cache.Hits) }},
+	{"cache_misses_total", "Cache misses.", "counter", func(s Stats) float64 { return float64(s.Cache.Misses) }},
+	{"cache_evictions_total", "Entries evicted to make room.", "counter", func(s Stats) float64 { return float64(s.Cache.Evictions) }},
+	{"cache_promotions_total", "Entries promoted to the frequent part of the cache.", "counter", func(s Stats) float64 { return float64(s.Cache.Promotions) }},
+	{"cache_bytes", "Bytes of the cached entries.", "gauge", func(s Stats) float64 { return float64(s.Cache.Bytes) }},
+	{"cache_max_bytes", "Capacity of the cache in bytes.", "gauge", func(s Stats) float64 { return float64(s.Cache.MaxBytes) }},
+	{"cache_items", "Cached entries.", "gauge", func(s Stats) float64 { return float64(s.Cache.Items) }},
+	{"service_gets_total", "Get calls.", "counter", func(s Stats) float64 { return float64(s.Gets) }},
+	{"service_loads_total", "Getter calls.", "counter", func(s Stats) float64 { return float64(s.Loads) }},
+	{"service_load_errors_total", "Failed getter calls.", "counter", func(s Stats) float64 { return float64(s.LoadErrors) }},
+	{"service_dedups_total", "Gets served by the load in flight of the same key.", "counter", func(s Stats) float64 { return float64(s.Dedups) }},
+	{"service_timeouts_total", "Gets timed out.", "counter", func(s Stats) float64 { return float64(s.Timeouts) }},
+	{"service_refreshes_total", "Background reloads of the entries about to expire or expired.", "counter", func(s Stats) float64 { return float64(s.Refreshes) }},
+	{"service_stale_hits_total", "Gets served by the expired value.", "counter", func(s Stats) float64 { return float64(s.StaleHits) }},
+	{"service_negative_hits_total", "Gets answered by the negative cache of the keys not in the db.", "counter", func(s Stats) float64 { return float64(s.NegativeHits) }},
+	{"service_negative_items", "Keys in the negative cache.", "gauge", func(s Stats) float64 { return float64(s.NegativeItems) }},
+	{"service_bloom_rejects_total", "Gets rejected by the bloom filter of the keys in the db.", "counter", func(s Stats) float64 { return float64(s.BloomRejects) }},
+	{"service_pending_writes", "Writes not flushed to the putter yet.", "gauge", func(s Stats) float64 { return float64(s.PendingWrites) }},
+	{"service_flush_errors_total", "Failed flushes of the pending writes.", "counter", func(s Stats) float64 { return float64(s.FlushErrors) }},
+}
+
+func collectStats(w *metrics.Writer) {
+	services := Services()
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].name < services[j].name
+	})
+	stats := make([]Stats, len(services))
+	for i, service := range services {
+		stats[i] = service.Stats()
+	}
+	for _, family := range statsFamilies {
+		w.Header(family.name, family.help, family.typ)
+		for i, service := range services {
+			w.Sample(family.name, family.value(stats[i]), metrics.Label{Name: "service", Value: service.name})
+		}
+	}
+}
+
+// Collectors export the metrics of all services
+func Collectors() []metrics.Collector {
+	return []metrics.Collector{metrics.CollectorFunc(collectStats), opDuration, loadDuration}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"distributed_cache/common"
+	"errors"
+	"sync"
+	"time"
+)
+
+// BatchGetter loads many keys in one call, GetMulti prefers it over Getter.Get,
+// the keys missing in the result are not in the db
+type BatchGetter interface {
+	GetBatch(keys []string) (map[string][]byte, error)
+}
+
+// BatchGetterContext is preferred over BatchGetter.GetBatch, the context is canceled
+// once the deadline of the GetMulti elapses
+type BatchGetterContext interface {
+	GetBatchContext(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+func (s *Service) GetMulti(keys []string) (map[string][]byte, error) {
+	return s.GetMultiContext(context.Background(), keys)
+}
+
+// GetMultiContext serves the cached keys locally and loads the misses,
+// through the BatchGetter in one call if the getter implements it, the batch load
+// is bounded by the deadline of the context and the timeout of the service,
+// the keys not in the db are missing in the result, the values loaded
+// are returned with the first error of the other keys
+func (s *Service) GetMultiContext(ctx context.Context, keys []string) (map[string][]byte, error) {
+	defer opDuration.With(s.name, "get_multi").ObserveSince(time.Now())
+	values := make(map[string][]byte, len(keys))
+	var misses []string
+	seen := make(map[string]struct{}, len(keys))
+	now := time.Now()
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		// the expired entries are left to Get, it knows the stale windows
+		if cacheEntry, err := s.cache.Get(key); err == nil {
+			if expire := expireOf(cacheEntry); expire.IsZero() || now.Before(expire) {
+				s.counters.gets.Add(1)
+				s.counters.flights.Add(1)
+				values[key] = cacheEntry.Bytes()
+				continue
+			}
+		}
+		misses = append(misses, key)
+	}
+	if len(misses) == 0 {
+		return values, nil
+	}
+	batch, single := s.splitMisses(misses)
+	var firstErr error
+	if len(batch) > 0 {
+		loaded, err := s.getBatch(ctx, batch)
+		for key, value := range loaded {
+			values[key] = value
+		}
+		firstErr = err
+	}
+
+	// the other misses are loaded by Get in parallel
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, key := range single {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := s.GetContext(ctx, key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				values[key] = value
+			} else if !errors.Is(err, common.ErrKeyNotInDB) && firstErr == nil {
+				firstErr = err
+			}
+		}(key)
+	}
+	wg.Wait()
+	return values, firstErr
+}
+
+// the misses loaded by the BatchGetter, and the ones left to Get,
+// which are owned by the peers, pending in the write-behind queue,
+// or the getter can't load in batch
+func (s *Service) splitMisses(misses []string) ([]string, []string) {
+	if !s.canGetBatch() {
+		return nil, misses
+	}
+	var batch, single []string
+	for _, key := range misses {
+		if s.peers != nil {
+			if _, ok := s.peers.PickPeer(key); ok {
+				single = append(single, key)
+				continue
+			}
+		}
+		if s.writeBehind != nil {
+			if _, ok := s.writeBehind.get(key); ok {
+				single = append(single, key)
+				continue
+			}
+		}
+		batch = append(batch, key)
+	}
+	return batch, single
+}
+
+func (s *Service) canGetBatch() bool {
+	switch s.getter.(type) {
+	case BatchGetterContext, BatchGetter:
+		return true
+	}
+	return false
+}
+
+// load the keys by the BatchGetter, the keys rejected by the bloom filter
+// or the negative cache are skipped
+func (s *Service) getBatch(ctx context.Context, keys []string) (map[string][]byte, error) {
+	s.counters.gets.Add(int64(len(keys)))
+	s.counters.flights.Add(int64(len(keys)))
+	var load []string
+	for _, key := range keys {
+		if s.rejected(key) {
+			s.counters.bloomRejects.Add(1)
+			continue
+		}
+		if s.missed(key) {
+			s.counters.negativeHits.Add(1)
+			continue
+		}
+		load = append(load, key)
+	}
+	if len(load) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	start := time.Now()
+	var values map[string][]byte
+	var err error
+	if getter, ok := s.getter.(BatchGetterContext); ok {
+		values, err = getter.GetBatchContext(ctx, load)
+	} else {
+		values, err = s.getter.(BatchGetter).GetBatch(load)
+	}
+	s.counters.loads.Add(1)
+	s.counters.loadNanos.Add(int64(time.Since(start)))
+	loadDuration.With(s.name).ObserveSince(start)
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.counters.loadErrors.Add(1)
+		s.counters.timeouts.Add(1)
+		s.log("service-%s: [DB batch timeout] %d keys", s.name, len(load))
+		return nil, common.ErrTimeout
+	}
+	if err != nil {
+		s.counters.loadErrors.Add(1)
+		s.log("service-%s: [DB batch failed] %d keys, err: %v", s.name, len(load), err)
+		return nil, err
+	}
+	loaded := make(map[string][]byte, len(values))
+	for _, key := range load {
+		if value, ok := values[key]; ok {
+			s.addKey(key)
+			s.populateCache(key, value, 0)
+			loaded[key] = value
+		} else {
+			s.rememberMiss(key)
+		}
+	}
+	s.log("service-%s: [DB batch] %d of %d keys hit", s.name, len(loaded), len(load))
+	return loaded, nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"distributed_cache/cache"
+	"distributed_cache/common"
+)
+
+// the negative cache remembers the keys which are not in the db,
+// so the lookups of them don't reach the getter until the entry expires
+type negativeCache struct {
+	cache   *cache.LRU
+	janitor *cache.Janitor
+}
+
+func newNegativeCache(maxBytes int64) (*negativeCache, error) {
+	lru, err := cache.NewLRU(maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	janitor, err := cache.StartJanitor(lru, common.JanitorInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &negativeCache{cache: lru, janitor: janitor}, nil
+}
+
+var notFound = cache.NewByteView(nil)
+
+// whether the key is known to be not in the db
+func (s *Service) missed(key string) bool {
+	if s.negative == nil {
+		return false
+	}
+	_, err := s.negative.cache.Get(key)
+	return err == nil
+}
+
+func (s *Service) rememberMiss(key string) {
+	if s.negative == nil {
+		return
+	}
+	if err := s.negative.cache.PutWithTTL(key, notFound, s.negativeTTL); err != nil {
+		s.log("service-%s: [ERROR] the miss of key %s can't store in negative cache", s.name, key)
+	}
+}
+
+// the key is written, it is in the db now
+func (s *Service) forgetMiss(key string) {
+	if s.negative != nil {
+		s.negative.cache.Delete(key)
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"distributed_cache/cache"
+	"time"
+)
+
+// Logger prints the debug logs of the service, *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+type Option func(s *Service)
+
+// use the cache instead of the one created by maxBytes and k,
+// it takes precedence over WithPolicy
+func WithCache(c cache.Cache) Option {
+	return func(s *Service) {
+		s.cache = c
+	}
+}
+
+// create the cache by the eviction policy registered in the cache package,
+// e.g. "lru", "lruk", "tinylfu", "arc", the default is "lruk"
+func WithPolicy(name string) Option {
+	return func(s *Service) {
+		s.policy = name
+	}
+}
+
+// the timeout of Get, common.TimeoutInterval by default
+func WithTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.timeout = d
+		}
+	}
+}
+
+// RemovalListener is called with the key, the value and the reason
+// once the entry leaves the cache, e.g. to release or write back the value
+type RemovalListener func(key string, value []byte, reason cache.RemovalReason)
+
+// the cache must implement cache.Notifier
+func WithRemovalListener(listener RemovalListener) Option {
+	return func(s *Service) {
+		s.onRemove = listener
+	}
+}
+
+// Put updates the cache and returns, the writes are flushed to the putter in background,
+// Close flushes the pending writes
+func WithWriteBehind(config WriteBehindConfig) Option {
+	return func(s *Service) {
+		s.writeBehindConfig = &config
+	}
+}
+
+// the Get within d before the entry expires serves the cached value
+// and reloads the entry in background, the ttl must be positive
+func WithRefreshAhead(d time.Duration) Option {
+	return func(s *Service) {
+		s.refreshAhead = max(d, 0)
+	}
+}
+
+// the Get within d after the entry expires serves the stale value
+// and reloads the entry in background, the ttl must be positive
+func WithStaleWhileRevalidate(d time.Duration) Option {
+	return func(s *Service) {
+		s.staleWhileRevalidate = max(d, 0)
+	}
+}
+
+// the Get within d after the entry expires reloads the entry,
+// and serves the stale value if the getter fails, the ttl must be positive,
+// common.ErrKeyNotInDB is not a failure, the deleted key is dropped from the cache
+func WithStaleIfError(d time.Duration) Option {
+	return func(s *Service) {
+		s.staleIfError = max(d, 0)
+	}
+}
+
+// cache the keys which the getter reports common.ErrKeyNotInDB for ttl,
+// in a separate LRU of maxBytes, Get returns common.ErrKeyNotInDB without
+// calling the getter until the entry expires or the key is written
+func WithNegativeCache(ttl time.Duration, maxBytes int64) Option {
+	return func(s *Service) {
+		s.negativeTTL = ttl
+		s.negativeBytes = maxBytes
+	}
+}
+
+// reject the Get of the key which is definitely not in the db before calling the getter,
+// the bloom filter is sized for the expected keys at the false positive rate,
+// populated by the keys on start and updated by the writes, the migrations and the loads
+// of the service, the keys written to the db bypassing the service are rejected
+// until the filter is rebuilt by WithBloomRebuild
+func WithBloomFilter(keys KeyEnumerator, expected int, falsePositiveRate float64) Option {
+	return func(s *Service) {
+		s.bloomConfig = &bloomConfig{
+			keys:              keys,
+			expected:          expected,
+			falsePositiveRate: falsePositiveRate,
+		}
+	}
+}
+
+// rebuild the bloom filter from the KeyEnumerator every interval,
+// so the keys written bypassing the service pass it and the deleted keys are dropped
+func WithBloomRebuild(interval time.Duration) Option {
+	return func(s *Service) {
+		s.bloomRebuild = interval
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(s *Service) {
+		if logger != nil {
+			s.logger = logger
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import "context"
+
+// PeerPicker locates the peer which owns the key,
+// ok is false if the key is owned by the node itself
+type PeerPicker interface {
+	PickPeer(key string) (peer PeerGetter, ok bool)
+}
+
+// PeerGetter loads the value of the key from the owning peer,
+// client.Client implements it
+type PeerGetter interface {
+	GetVersioned(serviceName string, key string) ([]byte, uint64, error)
+}
+
+// PeerWriter forwards the writes to the owning peer, so the owner calls
+// the putter and the deleter and keeps its copy fresh, client.Client implements it
+type PeerWriter interface {
+	PutContext(ctx context.Context, serviceName string, key string, value []byte) error
+	PutVersionedContext(ctx context.Context, serviceName string, key string, value []byte, version uint64) error
+	DeleteContext(ctx context.Context, serviceName string, key string) error
+}
+
+// the context key which marks the request forwarded by a peer
+type forwardedKey struct{}
+
+// Forwarded marks the request as forwarded by a peer, the service serves it
+// locally without picking the peer again, otherwise the peers whose rings
+// disagree, e.g. while the gossip converges, forward it back and forth
+func Forwarded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forwardedKey{}, true)
+}
+
+func isForwarded(ctx context.Context) bool {
+	forwarded, _ := ctx.Value(forwardedKey{}).(bool)
+	return forwarded
+}
+
+// PeerGetterContext is preferred over PeerGetter.GetVersioned,
+// so the peer bounds its load by the deadline of the context
+type PeerGetterContext interface {
+	GetVersionedContext(ctx context.Context, serviceName string, key string) ([]byte, uint64, error)
+}
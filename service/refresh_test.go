@@ -0,0 +1,136 @@
+package service
+
+import (
+	"distributed_cache/common"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// versionGetter returns the count of the loads as the value, fails once fail is set,
+// reports the key not in db once deleted is set
+type versionGetter struct {
+	loads   atomic.Int64
+	fail    atomic.Bool
+	deleted atomic.Bool
+}
+
+func (v *versionGetter) Get(key string) ([]byte, error) {
+	if v.fail.Load() {
+		return nil, errors.New("db is down")
+	}
+	if v.deleted.Load() {
+		return nil, common.ErrKeyNotInDB
+	}
+	return []byte(strconv.FormatInt(v.loads.Add(1), 10)), nil
+}
+
+func (v *versionGetter) Put(key string, value []byte) error {
+	return nil
+}
+
+// wait until the background reload updates the cache
+func waitValue(t *testing.T, service *Service, key string, want string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if value, err := service.cache.Get(key); err == nil && string(value.Bytes()) == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("the value of %s is not refreshed to %s", key, want)
+}
+
+func TestServiceRefreshAhead(t *testing.T) {
+	getter := &versionGetter{}
+	service := NewService("refresh-ahead", getter, getter, byteView, 2<<10, 2, 200*time.Millisecond,
+		WithRefreshAhead(150*time.Millisecond))
+	defer service.Close()
+	if v, err := service.Get("a"); err != nil || string(v) != "1" {
+		t.Fatalf("get a: %s, %v", v, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// within the window, the cached value is served and reloaded in background
+	if v, err := service.Get("a"); err != nil || string(v) != "1" {
+		t.Fatalf("get a: %s, %v", v, err)
+	}
+	waitValue(t, service, "a", "2")
+	if stats := service.Stats(); stats.Refreshes != 1 || stats.StaleHits != 0 {
+		t.Fatalf("%+v", stats)
+	}
+}
+
+func TestServiceStaleWhileRevalidate(t *testing.T) {
+	getter := &versionGetter{}
+	service := NewService("stale-while-revalidate", getter, getter, byteView, 2<<10, 2, 30*time.Millisecond,
+		WithStaleWhileRevalidate(time.Second))
+	defer service.Close()
+	service.Get("a")
+	time.Sleep(50 * time.Millisecond)
+	if v, err := service.Get("a"); err != nil || string(v) != "1" {
+		t.Fatalf("get a: %s, %v", v, err)
+	}
+	waitValue(t, service, "a", "2")
+	if stats := service.Stats(); stats.Refreshes != 1 || stats.StaleHits != 1 {
+		t.Fatalf("%+v", stats)
+	}
+
+	// the background reload drops the key deleted in the db
+	getter.deleted.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	service.Get("a")
+	deadline := time.Now().Add(time.Second)
+	for _, _, ok := service.Peek("a"); ok; _, _, ok = service.Peek("a") {
+		if time.Now().After(deadline) {
+			t.Fatal("the deleted key is still cached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServiceStaleIfError(t *testing.T) {
+	getter := &versionGetter{}
+	service := NewService("stale-if-error", getter, getter, byteView, 2<<10, 2, 30*time.Millisecond,
+		WithStaleIfError(time.Second))
+	defer service.Close()
+	service.Get("a")
+	time.Sleep(50 * time.Millisecond)
+	// the expired value is reloaded synchronously
+	if v, err := service.Get("a"); err != nil || string(v) != "2" {
+		t.Fatalf("get a: %s, %v", v, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	getter.fail.Store(true)
+	if v, err := service.Get("a"); err != nil || string(v) != "2" {
+		t.Fatalf("get a: %s, %v", v, err)
+	}
+	if stats := service.Stats(); stats.Refreshes != 0 || stats.StaleHits != 1 {
+		t.Fatalf("%+v", stats)
+	}
+
+	// the key deleted in the db is not served from the stale window
+	getter.fail.Store(false)
+	getter.deleted.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	if _, err := service.Get("a"); err != common.ErrKeyNotInDB {
+		t.Fatal(err)
+	}
+	if _, _, ok := service.Peek("a"); ok {
+		t.Fatal("the deleted key is still cached")
+	}
+	getter.deleted.Store(false)
+
+	// the getter error is returned without the stale window
+	service = NewService("stale-if-error-off", getter, getter, byteView, 2<<10, 2, 30*time.Millisecond)
+	defer service.Close()
+	getter.fail.Store(false)
+	service.Get("a")
+	time.Sleep(50 * time.Millisecond)
+	getter.fail.Store(true)
+	if _, err := service.Get("a"); err == nil {
+		t.Fatal("the expired value is served")
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"distributed_cache/cache"
 	"distributed_cache/common"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/singleflight"
 )
@@ -21,6 +23,23 @@ func (g GetterFunc) Get(key string) ([]byte, error) {
 	return g(key)
 }
 
+// GetterContext is preferred over Getter.Get, the context is canceled
+// once the deadline of the Get elapses
+type GetterContext interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterContextFunc implements both Getter and GetterContext
+type GetterContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (g GetterContextFunc) Get(key string) ([]byte, error) {
+	return g(context.Background(), key)
+}
+
+func (g GetterContextFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return g(ctx, key)
+}
+
 type Putter interface {
 	Put(key string, value []byte) error
 }
@@ -31,15 +50,76 @@ func (p PutterFunc) Put(key string, value []byte) error {
 	return p(key, value)
 }
 
+// PutterContext is preferred over Putter.Put by PutContext,
+// the write-behind flusher always calls Put
+type PutterContext interface {
+	PutContext(ctx context.Context, key string, value []byte) error
+}
+
+// PutterContextFunc implements both Putter and PutterContext
+type PutterContextFunc func(ctx context.Context, key string, value []byte) error
+
+func (p PutterContextFunc) Put(key string, value []byte) error {
+	return p(context.Background(), key, value)
+}
+
+func (p PutterContextFunc) PutContext(ctx context.Context, key string, value []byte) error {
+	return p(ctx, key, value)
+}
+
+type Deleter interface {
+	Delete(key string) error
+}
+
+type DeleterFunc func(key string) error
+
+func (d DeleterFunc) Delete(key string) error {
+	return d(key)
+}
+
 type Service struct {
 	name         string
 	cache        cache.Cache
 	getter       Getter // call when data not in cache
 	putter       Putter
+	deleter      Deleter        // optional, call when the key is deleted
 	newValueItem cache.NewValue // create the Value interface
 	group        *singleflight.Group
+	ttl          time.Duration // default ttl of the cache entry, <= 0 means never expire
+	janitor      *cache.Janitor
+	peers        PeerPicker // optional, find the owning peer on a cache miss
+	policy       string     // eviction policy of the cache
+	timeout      time.Duration
+	logger       Logger
+	onRemove     RemovalListener // optional, call when the entry leaves the cache
+	counters     counters
+
+	writeBehindConfig *WriteBehindConfig
+	writeBehind       *writeBehind // nil means the putter is called synchronously
+
+	refreshAhead         time.Duration // reload the entry which expires within it
+	staleWhileRevalidate time.Duration // serve the expired entry within it and reload it
+	staleIfError         time.Duration // serve the expired entry within it if the reload fails
+
+	negativeTTL   time.Duration
+	negativeBytes int64
+	negative      *negativeCache // nil unless the misses of the getter are cached
+
+	bloomConfig  *bloomConfig
+	bloomRebuild time.Duration
+	bloom        *bloomFilter // nil unless the keys not in the db are rejected by it
 }
 
+// the singleflight key of the background reload, distinct from the key of Get
+const refreshPrefix = "\x00refresh\x00"
+
+// the singleflight key of the Get forwarded by a peer, it must not join
+// the Get of the node which is forwarding the key to that peer
+const forwardedPrefix = "\x00forwarded\x00"
+
+// eviction policy of the service created without WithPolicy or WithCache
+var DefaultPolicy = "lruk"
+
 var (
 	mu sync.RWMutex
 	// store many service
@@ -55,23 +135,63 @@ func ViewServiceGroup() {
 }
 
 // create the Service instance
-func NewService(name string, getter Getter, putter Putter, newValueItem cache.NewValue, maxBytes int64, k int) *Service {
+// ttl: default ttl of the cache entry, <= 0 means never expire
+// the cache is LRU-k of maxBytes and k unless the options choose another one
+func NewService(name string, getter Getter, putter Putter, newValueItem cache.NewValue, maxBytes int64, k int, ttl time.Duration, opts ...Option) *Service {
 	mu.RLock()
 	if _, ok := groups[name]; ok {
 		panic("service is already existed")
 	}
 	mu.RUnlock()
-	lruk, err := cache.NewLRUK(maxBytes, k)
-	if err != nil {
-		panic(err)
-	}
 	service := &Service{
 		name:         name,
-		cache:        lruk,
 		getter:       getter,
 		putter:       putter,
 		newValueItem: newValueItem,
 		group:        &singleflight.Group{},
+		ttl:          ttl,
+		policy:       DefaultPolicy,
+		timeout:      common.TimeoutInterval,
+		logger:       log.Default(),
+	}
+	for _, opt := range opts {
+		opt(service)
+	}
+	if service.cache == nil {
+		c, err := cache.NewPolicy(service.policy, maxBytes, k)
+		if err != nil {
+			panic(err)
+		}
+		service.cache = c
+	}
+	if service.onRemove != nil {
+		notifier, ok := service.cache.(cache.Notifier)
+		if !ok {
+			panic(common.ErrListenerNotSupported)
+		}
+		notifier.SetRemovalListener(service.removed)
+	}
+	if service.writeBehindConfig != nil {
+		w, err := newWriteBehind(putter, *service.writeBehindConfig)
+		if err != nil {
+			panic(err)
+		}
+		service.writeBehind = w
+	}
+	if service.negativeTTL > 0 {
+		negative, err := newNegativeCache(service.negativeBytes)
+		if err != nil {
+			panic(err)
+		}
+		service.negative = negative
+	}
+	if service.bloomConfig != nil {
+		config := *service.bloomConfig
+		config.rebuild = service.bloomRebuild
+		service.bloom = service.newBloomFilter(config)
+	}
+	if ttl > 0 {
+		service.janitor, _ = cache.StartJanitor(service.cache, common.JanitorInterval)
 	}
 	mu.Lock()
 	groups[name] = service
@@ -81,80 +201,316 @@ func NewService(name string, getter Getter, putter Putter, newValueItem cache.Ne
 
 func (h *Service) log(format string, v ...any) {
 	if common.DEBUG {
-		log.Printf(format, v...)
+		h.logger.Printf(format, v...)
 	}
 }
 
-// load data from local
-func (s *Service) load(key string) ([]byte, error) {
-	return s.getlocally(key)
+func (s *Service) removed(key string, value cache.Value, reason cache.RemovalReason) {
+	s.log("service-%s: [Removed] key %s, reason %v", s.name, key, reason)
+	s.onRemove(key, value.Bytes(), reason)
+}
+
+// load data from the owning peer, or from local if the node owns the key
+// or the peer is unreachable
+func (s *Service) load(ctx context.Context, key string) (versionedBytes, error) {
+	if s.peers != nil && !isForwarded(ctx) {
+		if peer, ok := s.peers.PickPeer(key); ok {
+			value, err := s.getFromPeer(ctx, peer, key)
+			if err == nil {
+				s.addKey(key)
+				return value, nil
+			}
+			// the owner has looked up the db already
+			if errors.Is(err, common.ErrKeyNotInDB) {
+				return versionedBytes{}, err
+			}
+			s.log("service-%s: [Peer failed] key %s, err: %v", s.name, key, err)
+		}
+	}
+	return s.getlocally(ctx, key)
+}
+
+// the value from the peer is not cached, the owner keeps the only copy
+func (s *Service) getFromPeer(ctx context.Context, peer PeerGetter, key string) (versionedBytes, error) {
+	var value []byte
+	var version uint64
+	var err error
+	if p, ok := peer.(PeerGetterContext); ok {
+		value, version, err = p.GetVersionedContext(ctx, s.name, key)
+	} else {
+		value, version, err = peer.GetVersioned(s.name, key)
+	}
+	if err != nil {
+		return versionedBytes{}, err
+	}
+	s.log("service-%s: [Peer hit] get the value %s of the key %s", s.name, value, key)
+	return versionedBytes{value: value, version: version}, nil
+}
+
+// the owning peer which accepts the writes of the key,
+// nil if the node owns it or the write is forwarded by a peer
+func (s *Service) writerOf(ctx context.Context, key string) PeerWriter {
+	if s.peers == nil || isForwarded(ctx) {
+		return nil
+	}
+	peer, ok := s.peers.PickPeer(key)
+	if !ok {
+		return nil
+	}
+	writer, _ := peer.(PeerWriter)
+	return writer
 }
 
 // call Get method in getter interface
-func (s *Service) getlocally(key string) ([]byte, error) {
-	value, err := s.getter.Get(key)
+func (s *Service) getlocally(ctx context.Context, key string) (versionedBytes, error) {
+	// the write is not flushed yet, the getter has the stale value
+	if s.writeBehind != nil {
+		if value, ok := s.writeBehind.get(key); ok {
+			s.populateCache(key, value, 0)
+			return versionedBytes{value: value}, nil
+		}
+	}
+	if s.rejected(key) {
+		s.counters.bloomRejects.Add(1)
+		s.log("service-%s: [Bloom rejected] the key %s is not in db", s.name, key)
+		return versionedBytes{}, common.ErrKeyNotInDB
+	}
+	if s.missed(key) {
+		s.counters.negativeHits.Add(1)
+		s.log("service-%s: [Negative hit] the key %s is not in db", s.name, key)
+		return versionedBytes{}, common.ErrKeyNotInDB
+	}
+	start := time.Now()
+	var value []byte
+	var err error
+	if getter, ok := s.getter.(GetterContext); ok {
+		value, err = getter.GetContext(ctx, key)
+	} else {
+		value, err = s.getter.Get(key)
+	}
+	s.counters.loads.Add(1)
+	s.counters.loadNanos.Add(int64(time.Since(start)))
+	loadDuration.With(s.name).ObserveSince(start)
 	if err != nil {
+		s.counters.loadErrors.Add(1)
 		s.log("service-%s: [DB not hit], err: %v", s.name, err)
-		return nil, err
+		if errors.Is(err, common.ErrKeyNotInDB) {
+			s.rememberMiss(key)
+		}
+		return versionedBytes{}, err
 	}
 	s.log("service-%s: [DB hit] get the value %s of the key %s", s.name, value, key)
-	s.populateCache(key, value)
-	return value, nil
+	s.addKey(key)
+	s.populateCache(key, value, 0)
+	return versionedBytes{value: value}, nil
 }
 
 // update the cache
-func (s *Service) populateCache(key string, value []byte) {
-	err := s.cache.Put(key, s.newValueItem.New(value))
+func (s *Service) populateCache(key string, value []byte, version uint64) {
+	err := s.cache.PutWithTTL(key, s.newCacheValue(value, version), s.cacheTTL())
 	if err != nil {
 		s.log("service-%s: [ERROR] data[key%s] can't store in cache", s.name, key)
 	}
 }
 
+func (s *Service) newCacheValue(value []byte, version uint64) cache.Value {
+	var expire time.Time
+	if s.ttl > 0 {
+		expire = time.Now().Add(s.ttl)
+	}
+	return versionedValue{
+		Value:   s.newValueItem.New(value),
+		version: version,
+		expire:  expire,
+	}
+}
+
+// the entry stays in the cache during the stale windows after it expires
+func (s *Service) cacheTTL() time.Duration {
+	if s.ttl <= 0 {
+		return s.ttl
+	}
+	return s.ttl + max(s.staleWhileRevalidate, s.staleIfError)
+}
+
 // Get
-func (s *Service) get(key string) ([]byte, error) {
-	var (
-		value []byte
-		err   error
-	)
+func (s *Service) get(ctx context.Context, key string) (versionedBytes, error) {
 	cacheEntry, err := s.cache.Get(key)
 	if err != nil { // cache not hit
-		return s.load(key)
+		return s.load(ctx, key)
+	}
+	value := versionedBytes{value: cacheEntry.Bytes(), version: versionOf(cacheEntry)}
+	if expire := expireOf(cacheEntry); !expire.IsZero() {
+		now := time.Now()
+		switch {
+		case now.Before(expire.Add(-s.refreshAhead)):
+		case now.Before(expire):
+			// about to expire, reload it before the Gets block on the getter
+			s.refresh(key)
+		case now.Before(expire.Add(s.staleWhileRevalidate)):
+			s.log("service-%s: [Stale hit] serve the stale value of the key %s", s.name, key)
+			s.counters.staleHits.Add(1)
+			s.refresh(key)
+			return value, nil
+		default:
+			loaded, err := s.load(ctx, key)
+			// the key deleted in the db is not a failure of the reload
+			if errors.Is(err, common.ErrKeyNotInDB) {
+				s.cache.Delete(key)
+				return loaded, err
+			}
+			if err != nil && now.Before(expire.Add(s.staleIfError)) {
+				s.log("service-%s: [Stale hit] serve the stale value of the key %s, err: %v", s.name, key, err)
+				s.counters.staleHits.Add(1)
+				return value, nil
+			}
+			return loaded, err
+		}
 	}
 	// cache hit
-	value = cacheEntry.Bytes()
-	s.log("service-%s: [Cache hit] get the value %s of the key %s", s.name, value, key)
+	s.log("service-%s: [Cache hit] get the value %s of the key %s", s.name, value.value, key)
 	return value, nil
 }
 
-func (s *Service) Get(key string) ([]byte, error) {
-	doC := s.group.DoChan(key, func() (interface{}, error) {
-		return s.get(key)
+// reload the key in background through the singleflight group,
+// the key is refreshed by one load at a time
+func (s *Service) refresh(key string) {
+	s.group.DoChan(refreshPrefix+key, func() (interface{}, error) {
+		s.counters.refreshes.Add(1)
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		value, err := s.load(ctx, key)
+		if errors.Is(err, common.ErrKeyNotInDB) {
+			// the stale value of the deleted key must not be served any more
+			s.cache.Delete(key)
+		}
+		if err != nil {
+			s.log("service-%s: [Refresh failed] key %s, err: %v", s.name, key, err)
+		}
+		return value, err
 	})
-	ctx, cancel := context.WithTimeout(context.TODO(), common.TimeoutInterval)
+}
+
+func (s *Service) Get(key string) ([]byte, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+// GetContext returns once the value is loaded, the deadline of the context
+// or the timeout of the service elapses, or the context is canceled
+func (s *Service) GetContext(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := s.GetVersionedContext(ctx, key)
+	return value, err
+}
+
+// GetVersioned returns the value with its write version,
+// the version is 0 if the value is loaded by the getter
+func (s *Service) GetVersioned(key string) ([]byte, uint64, error) {
+	return s.GetVersionedContext(context.Background(), key)
+}
+
+func (s *Service) GetVersionedContext(ctx context.Context, key string) ([]byte, uint64, error) {
+	defer opDuration.With(s.name, "get").ObserveSince(time.Now())
+	s.counters.gets.Add(1)
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	select {
-	case val := <-doC:
-		return val.Val.([]byte), val.Err
-	case <-ctx.Done():
-		s.log("service-%s: Get key %s timeout", s.name, key)
-		// dead lock!
-		go func() {
-			<-doC
-		}()
-		return nil, common.ErrTimeout
+	flight := key
+	if isForwarded(ctx) {
+		flight = forwardedPrefix + key
+	}
+	for {
+		doC := s.group.DoChan(flight, func() (interface{}, error) {
+			s.counters.flights.Add(1)
+			// the load is shared by the Gets of the key, it is bounded by
+			// the timeout of the service, not by the deadline of any of them
+			loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.timeout)
+			defer cancel()
+			return s.get(loadCtx, key)
+		})
+		select {
+		case val := <-doC:
+			// every caller decides the timeout by its own deadline
+			if val.Err != nil && ctx.Err() == context.DeadlineExceeded {
+				return nil, 0, s.timedOut(key)
+			}
+			// the joined load started earlier and timed out, the caller has time left
+			if errors.Is(val.Err, context.DeadlineExceeded) {
+				continue
+			}
+			res := val.Val.(versionedBytes)
+			return res.value, res.version, val.Err
+		case <-ctx.Done():
+			// dead lock!
+			go func() {
+				<-doC
+			}()
+			if ctx.Err() == context.Canceled {
+				return nil, 0, ctx.Err()
+			}
+			return nil, 0, s.timedOut(key)
+		}
 	}
 }
 
+func (s *Service) timedOut(key string) error {
+	s.log("service-%s: Get key %s timeout", s.name, key)
+	s.counters.timeouts.Add(1)
+	return common.ErrTimeout
+}
+
 // Put
 func (s *Service) Put(key string, value []byte) error {
+	return s.PutContext(context.Background(), key, value)
+}
+
+// PutContext passes the context to the putter if it implements PutterContext
+func (s *Service) PutContext(ctx context.Context, key string, value []byte) error {
+	return s.put(ctx, key, value, 0)
+}
+
+// PutVersioned
+// the write is ignored if the cached value has a newer version
+func (s *Service) PutVersioned(key string, value []byte, version uint64) error {
+	return s.PutVersionedContext(context.Background(), key, value, version)
+}
+
+func (s *Service) PutVersionedContext(ctx context.Context, key string, value []byte, version uint64) error {
+	if s.stale(key, version) {
+		return nil
+	}
+	return s.put(ctx, key, value, version)
+}
+
+// whether the cached value is newer than the version
+func (s *Service) stale(key string, version uint64) bool {
+	// peek, the version check is not an access of the key
+	if cacheEntry, err := s.cache.Peek(key); err == nil && versionOf(cacheEntry) > version {
+		s.log("service-%s: ignore the stale write of key %s, version %d < %d", s.name, key, version, versionOf(cacheEntry))
+		return true
+	}
+	return false
+}
+
+func (s *Service) put(ctx context.Context, key string, value []byte, version uint64) error {
+	defer opDuration.With(s.name, "put").ObserveSince(time.Now())
+	if peer := s.writerOf(ctx, key); peer != nil {
+		return s.putToPeer(ctx, peer, key, value, version)
+	}
 	// may be not consistent
 	var err error
-	err = s.putter.Put(key, value)
+	if s.writeBehind != nil {
+		err = s.writeBehind.enqueue(key, value)
+	} else if putter, ok := s.putter.(PutterContext); ok {
+		err = putter.PutContext(ctx, key, value)
+	} else {
+		err = s.putter.Put(key, value)
+	}
 	if err != nil {
 		return err
 	}
+	s.addKey(key)
+	s.forgetMiss(key)
 	// s.log("service-%s: put [%s, %v] in putter", s.name, key, value)
-	err = s.cache.Put(key, s.newValueItem.New(value))
+	err = s.cache.PutWithTTL(key, s.newCacheValue(value, version), s.cacheTTL())
 	if err != nil {
 		return err
 	}
@@ -162,10 +518,154 @@ func (s *Service) Put(key string, value []byte) error {
 	return nil
 }
 
+// the owner writes the putter and caches the value, the local copy is dropped
+// as the value from the peer is not cached
+func (s *Service) putToPeer(ctx context.Context, peer PeerWriter, key string, value []byte, version uint64) error {
+	var err error
+	if version > 0 {
+		err = peer.PutVersionedContext(ctx, s.name, key, value, version)
+	} else {
+		err = peer.PutContext(ctx, s.name, key, value)
+	}
+	if err != nil {
+		return err
+	}
+	s.log("service-%s: put [%s, %v] to the owning peer", s.name, key, value)
+	s.addKey(key)
+	s.forgetMiss(key)
+	s.cache.Delete(key)
+	return nil
+}
+
+// Delete
+// remove the key from the cache, call the deleter first if it is set,
+// it is not an error if the key is not in the cache
+func (s *Service) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext forwards the deletion to the owning peer in the peer-to-peer mode,
+// the context bounds the forwarding only
+func (s *Service) DeleteContext(ctx context.Context, key string) error {
+	defer opDuration.With(s.name, "delete").ObserveSince(time.Now())
+	if peer := s.writerOf(ctx, key); peer != nil {
+		if err := peer.DeleteContext(ctx, s.name, key); err != nil {
+			return err
+		}
+		s.log("service-%s: delete key %s on the owning peer", s.name, key)
+		s.cache.Delete(key)
+		return nil
+	}
+	if s.deleter != nil {
+		var err error
+		if s.writeBehind != nil {
+			// the pending write must not bring the key back,
+			// without the deleter it is still flushed to the putter
+			err = s.writeBehind.delete(key, s.deleter.Delete)
+		} else {
+			err = s.deleter.Delete(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err := s.cache.Delete(key)
+	if err != nil && err != common.ErrKeyNotInCache {
+		return err
+	}
+	s.log("service-%s: delete key %s in cache", s.name, key)
+	return nil
+}
+
+// Populate writes the value into the cache only, without calling the putter,
+// used when the entries are migrated between the cache nodes
+func (s *Service) Populate(key string, value []byte, version uint64) error {
+	if s.stale(key, version) {
+		return nil
+	}
+	s.addKey(key)
+	s.forgetMiss(key)
+	return s.cache.PutWithTTL(key, s.newCacheValue(value, version), s.cacheTTL())
+}
+
+// Peek returns the cached value without loading it from the getter,
+// the eviction order and the cache stats are not changed
+func (s *Service) Peek(key string) ([]byte, uint64, bool) {
+	cacheEntry, err := s.cache.Peek(key)
+	if err != nil {
+		return nil, 0, false
+	}
+	return cacheEntry.Bytes(), versionOf(cacheEntry), true
+}
+
+// Evict removes the key from the cache only, without calling the deleter
+func (s *Service) Evict(key string) {
+	s.cache.Delete(key)
+}
+
+// keys in the cache
+func (s *Service) Keys() []string {
+	return s.cache.Keys()
+}
+
+func (s *Service) Name() string {
+	return s.name
+}
+
+// set the deleter, must be called before the service is used
+func (s *Service) SetDeleter(deleter Deleter) {
+	s.deleter = deleter
+}
+
+// set the peer picker, must be called before the service is used
+func (s *Service) RegisterPeers(peers PeerPicker) {
+	if s.peers != nil {
+		panic("RegisterPeers called more than once")
+	}
+	s.peers = peers
+}
+
+// Flush writes the pending writes to the putter now, only in the write-behind mode
+func (s *Service) Flush() error {
+	if s.writeBehind == nil {
+		return nil
+	}
+	return s.writeBehind.flush()
+}
+
+// Close stops the background jobs and flushes the pending writes,
+// the service must not be used after Close
+func (s *Service) Close() error {
+	if s.janitor != nil {
+		s.janitor.Stop()
+	}
+	if s.negative != nil {
+		s.negative.janitor.Stop()
+	}
+	if s.bloom != nil {
+		s.bloom.close()
+	}
+	if s.writeBehind != nil {
+		return s.writeBehind.close()
+	}
+	return nil
+}
+
 func (s *Service) ViewCache() {
 	s.cache.View()
 }
 
+// all the services in the group
+func Services() []*Service {
+	mu.RLock()
+	defer mu.RUnlock()
+	services := make([]*Service, 0, len(groups))
+	for _, service := range groups {
+		services = append(services, service)
+	}
+	return services
+}
+
 func GetService(name string) (*Service, error) {
 	mu.RLock()
 	defer mu.RUnlock()
@@ -1,14 +1,21 @@
 package service
 
 import (
+	"context"
 	"distributed_cache/cache"
+	"distributed_cache/common"
 	"errors"
 	"fmt"
+	"log"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type Mapper struct {
@@ -37,6 +44,353 @@ func (m *Mapper) Put(key string, value []byte) error {
 	return nil
 }
 
+func (m *Mapper) Delete(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.db, key)
+	return nil
+}
+
+func TestServiceDelete(t *testing.T) {
+	var f = cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	})
+	mapper := &Mapper{
+		db: map[string][]byte{"1": []byte("1")},
+	}
+	service := NewService("delete", mapper, mapper, f, 2<<5, 2, 0)
+	if _, err := service.Get("1"); err != nil {
+		t.Fatal(err)
+	}
+	// invalidate the cache only
+	if err := service.Delete("1"); err != nil {
+		t.Fail()
+	}
+	if _, err := service.cache.Get("1"); err == nil {
+		t.Fail()
+	}
+	if err := service.Delete("1"); err != nil {
+		fmt.Println("delete the key not in cache must not fail")
+		t.Fail()
+	}
+	// call through the deleter
+	service.SetDeleter(mapper)
+	service.Get("1")
+	service.Delete("1")
+	if _, err := service.Get("1"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestServicePutVersioned(t *testing.T) {
+	var f = cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	})
+	mapper := &Mapper{
+		db: map[string][]byte{"1": []byte("1")},
+	}
+	service := NewService("versioned", mapper, mapper, f, 2<<5, 2, 0)
+	if _, version, err := service.GetVersioned("1"); err != nil || version != 0 {
+		t.Fail()
+	}
+	service.PutVersioned("1", []byte("2"), 2)
+	// the stale write is ignored
+	service.PutVersioned("1", []byte("3"), 1)
+	value, version, err := service.GetVersioned("1")
+	if err != nil || string(value) != "2" || version != 2 {
+		fmt.Printf("get value %s version %d, but should be 2\n", value, version)
+		t.Fail()
+	}
+	// the unversioned write always overrides
+	service.Put("1", []byte("4"))
+	if value, version, _ := service.GetVersioned("1"); string(value) != "4" || version != 0 {
+		t.Fail()
+	}
+}
+
+func TestServicePutVersionedNotAccess(t *testing.T) {
+	var f = cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	})
+	mapper := &Mapper{db: map[string][]byte{}}
+	service := NewService("versioned-access", mapper, mapper, f, 2<<5, 2, 0)
+	service.PutVersioned("1", []byte("1"), 1)
+	service.PutVersioned("1", []byte("2"), 2)
+	// the version checks are neither hits nor misses
+	stats := service.Stats().Cache
+	if stats.Hits != 0 || stats.Misses != 0 || stats.Promotions != 0 {
+		t.Fatalf("%+v", stats)
+	}
+}
+
+func TestServicePeek(t *testing.T) {
+	var f = cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	})
+	mapper := &Mapper{db: map[string][]byte{}}
+	service := NewService("peek", mapper, mapper, f, 2<<5, 2, 0)
+	service.Put("1", []byte("1"))
+	for i := 0; i < 3; i++ {
+		if value, _, ok := service.Peek("1"); !ok || string(value) != "1" {
+			t.Fatalf("peek %s %v", value, ok)
+		}
+		if _, _, ok := service.Peek("2"); ok {
+			t.Fatal("the key not in the cache must not be peeked")
+		}
+	}
+	stats := service.Stats().Cache
+	if stats.Hits != 0 || stats.Misses != 0 || stats.Promotions != 0 {
+		t.Fatalf("%+v", stats)
+	}
+}
+
+// the keys start with "peer" are owned by the peer
+type fakePicker struct {
+	peer *fakePeer
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	if strings.HasPrefix(key, "peer") {
+		return p.peer, true
+	}
+	return nil, false
+}
+
+type fakePeer struct {
+	calls  atomic.Int32
+	down   bool
+	mu     sync.Mutex
+	writes []string // the forwarded writes, "op key value version"
+}
+
+func (p *fakePeer) write(op string, key string, value []byte, version uint64) error {
+	if p.down {
+		return errors.New("peer is down")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writes = append(p.writes, fmt.Sprintf("%s %s %s %d", op, key, value, version))
+	return nil
+}
+
+func (p *fakePeer) PutContext(ctx context.Context, serviceName string, key string, value []byte) error {
+	return p.write("put", key, value, 0)
+}
+
+func (p *fakePeer) PutVersionedContext(ctx context.Context, serviceName string, key string, value []byte, version uint64) error {
+	return p.write("put", key, value, version)
+}
+
+func (p *fakePeer) DeleteContext(ctx context.Context, serviceName string, key string) error {
+	return p.write("delete", key, nil, 0)
+}
+
+func (p *fakePeer) GetVersioned(serviceName string, key string) ([]byte, uint64, error) {
+	p.calls.Add(1)
+	if p.down {
+		return nil, 0, errors.New("peer is down")
+	}
+	return []byte("remote-" + key), 1, nil
+}
+
+func TestServicePeers(t *testing.T) {
+	var f = cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	})
+	mapper := &Mapper{
+		db: map[string][]byte{"1": []byte("1"), "peer1": []byte("local")},
+	}
+	service := NewService("peers", mapper, mapper, f, 2<<5, 2, 0)
+	peer := &fakePeer{}
+	service.RegisterPeers(&fakePicker{peer: peer})
+	// owned by the node itself
+	if value, err := service.Get("1"); err != nil || string(value) != "1" || peer.calls.Load() != 0 {
+		t.Fail()
+	}
+	// owned by the peer, not cached locally
+	for i := 0; i < 2; i++ {
+		value, version, err := service.GetVersioned("peer1")
+		if err != nil || string(value) != "remote-peer1" || version != 1 {
+			fmt.Printf("get value %s version %d from the peer\n", value, version)
+			t.Fail()
+		}
+	}
+	if peer.calls.Load() != 2 {
+		t.Fail()
+	}
+	// fall back to the getter if the peer is unreachable
+	peer.down = true
+	if value, err := service.Get("peer1"); err != nil || string(value) != "local" {
+		t.Fail()
+	}
+}
+
+func TestServicePeerWrites(t *testing.T) {
+	var f = cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	})
+	mapper := &Mapper{db: map[string][]byte{}}
+	service := NewService("peer-writes", mapper, mapper, f, 2<<5, 2, 0)
+	peer := &fakePeer{}
+	service.RegisterPeers(&fakePicker{peer: peer})
+	// the copy cached before the node lost the key
+	service.Populate("peer1", []byte("old"), 0)
+	if err := service.Put("peer1", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.PutVersioned("peer1", []byte("2"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Delete("peer1"); err != nil {
+		t.Fatal(err)
+	}
+	// the owned key is written locally
+	service.Put("1", []byte("1"))
+	ans := []string{"put peer1 1 0", "put peer1 2 2", "delete peer1  0"}
+	if strings.Join(peer.writes, ",") != strings.Join(ans, ",") {
+		t.Fatalf("get forwarded writes %q", peer.writes)
+	}
+	if _, ok := mapper.db["peer1"]; ok || string(mapper.db["1"]) != "1" {
+		t.Fatal("only the owned key is written to the putter")
+	}
+	if _, _, ok := service.Peek("peer1"); ok {
+		t.Fatal("the local copy of the forwarded write must be dropped")
+	}
+	peer.down = true
+	if err := service.Put("peer1", []byte("3")); err == nil {
+		t.Fatal("the write must fail if the owner is unreachable")
+	}
+}
+
+func TestServiceOptions(t *testing.T) {
+	var f = cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	})
+	mapper := &Mapper{
+		db: map[string][]byte{"1": []byte("1")},
+	}
+	// LRU-k by default
+	service := NewService("options-default", mapper, mapper, f, 2<<5, 2, 0)
+	if _, ok := service.cache.(*cache.LRUK); !ok {
+		t.Fail()
+	}
+	service = NewService("options-policy", mapper, mapper, f, 2<<5, 2, 0, WithPolicy("arc"))
+	if _, ok := service.cache.(*cache.ARC); !ok {
+		t.Fail()
+	}
+	// the cache takes precedence over the policy
+	lru, _ := cache.NewLRU(2 << 5)
+	var buf strings.Builder
+	service = NewService("options-cache", mapper, mapper, f, 2<<5, 2, 0,
+		WithPolicy("arc"), WithCache(lru), WithLogger(log.New(&buf, "", 0)), WithTimeout(time.Second))
+	if service.cache != lru || service.timeout != time.Second {
+		t.Fail()
+	}
+	if value, err := service.Get("1"); err != nil || string(value) != "1" || len(lru.Keys()) != 1 {
+		t.Fail()
+	}
+	if common.DEBUG && !strings.Contains(buf.String(), "service-options-cache") {
+		t.Fatal("the logs must go to the logger")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("the unknown policy must panic")
+		}
+	}()
+	NewService("options-unknown", mapper, mapper, f, 2<<5, 2, 0, WithPolicy("unknown"))
+}
+
+func TestServiceTinyLFU(t *testing.T) {
+	mapper := &Mapper{db: map[string][]byte{"1": []byte("1")}}
+	service := NewService("tinylfu", mapper, mapper, byteView, 2<<10, 2, 0, WithPolicy("tinylfu"))
+	defer service.Close()
+	if _, ok := service.cache.(*cache.TinyLFU); !ok {
+		t.Fatal("the cache must be TinyLFU")
+	}
+	if value, err := service.Get("1"); err != nil || string(value) != "1" {
+		t.Fatal(value, err)
+	}
+	service.Put("2", []byte("2"))
+	if value, _, ok := service.Peek("2"); !ok || string(value) != "2" {
+		t.Fatal("the written value must be cached")
+	}
+}
+
+func TestServiceStats(t *testing.T) {
+	var f = cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	})
+	release := make(chan struct{})
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if key == "slow" {
+			<-release
+		}
+		if key == "none" {
+			return nil, errors.New("not found")
+		}
+		return []byte(key), nil
+	})
+	service := NewService("stats", getter, PutterFunc(func(key string, value []byte) error {
+		return nil
+	}), f, 2<<5, 2, 0, WithTimeout(50*time.Millisecond))
+	service.Get("1")
+	service.Get("1")
+	service.Get("none")
+	// the concurrent Gets share one load, and all of them time out
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.Get("slow")
+		}()
+	}
+	wg.Wait()
+	stats := service.Stats()
+	close(release)
+	if stats.Gets != 6 || stats.Loads != 2 || stats.LoadErrors != 1 || stats.Dedups != 2 || stats.Timeouts != 3 {
+		t.Fatalf("%+v", stats)
+	}
+	if stats.Cache.Hits != 1 || stats.Cache.Items != 1 {
+		t.Fatalf("%+v", stats.Cache)
+	}
+}
+
+func TestServiceRemovalListener(t *testing.T) {
+	var f = cache.NewValueFunc(func(b []byte) cache.Value {
+		return cache.NewByteView(b)
+	})
+	mapper := &Mapper{
+		db: map[string][]byte{},
+	}
+	var mu sync.Mutex
+	removed := make(map[string]cache.RemovalReason)
+	service := NewService("removal", mapper, mapper, f, 20, 2, 0,
+		WithPolicy("lru"),
+		WithRemovalListener(func(key string, value []byte, reason cache.RemovalReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			removed[key+"="+string(value)] = reason
+		}))
+	service.Put("1", []byte("1"))
+	service.Put("2", []byte("2"))
+	service.Delete("1")
+	service.Put("3", []byte("3"))
+	service.Put("4", []byte("4"))
+	mu.Lock()
+	defer mu.Unlock()
+	if len(removed) != 2 || removed["1=1"] != cache.RemovedByDelete || removed["2=2"] != cache.RemovedByCapacity {
+		t.Fatal(removed)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("the cache without the notifier must panic")
+		}
+	}()
+	NewService("removal-arc", mapper, mapper, f, 4, 2, 0, WithPolicy("arc"),
+		WithRemovalListener(func(key string, value []byte, reason cache.RemovalReason) {}))
+}
+
 func TestServerGetter(t *testing.T) {
 	lruk, _ := cache.NewLRUK(10, 2)
 	for i := 0; i < 3; i++ {
@@ -63,6 +417,9 @@ func TestServerGetter(t *testing.T) {
 		getter:       m,
 		cache:        lruk,
 		newValueItem: f,
+		group:        &singleflight.Group{},
+		timeout:      common.TimeoutInterval,
+		logger:       log.Default(),
 	}
 
 	// fmt.Println(m)
@@ -103,6 +460,7 @@ func TestServiceConcurrent(t *testing.T) {
 				f,
 				2<<10,
 				2,
+				0,
 			)
 		}(ii)
 	}
@@ -147,6 +505,7 @@ func TestServiceCacheConcurrent(t *testing.T) {
 		f,
 		2<<5,
 		2,
+		0,
 	)
 	for i := 0; i < nWrite; i++ {
 		ii := i
@@ -178,3 +537,303 @@ func TestServiceCacheConcurrent(t *testing.T) {
 	fmt.Printf("hit rate is %.2f\n", float32(hitCount)/float32(count))
 	fmt.Println(count, hitCount)
 }
+
+func TestServiceNegativeCache(t *testing.T) {
+	var loads atomic.Int64
+	db := &Mapper{db: map[string][]byte{}}
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		if _, err := db.Get(key); err != nil {
+			return nil, common.ErrKeyNotInDB
+		}
+		return db.Get(key)
+	})
+	service := NewService("negative", getter, db, byteView, 2<<5, 2, 0,
+		WithNegativeCache(50*time.Millisecond, 2<<5))
+	defer service.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := service.Get("none"); err != common.ErrKeyNotInDB {
+			t.Fatal(err)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Fatalf("the getter is called %d times", loads.Load())
+	}
+	if stats := service.Stats(); stats.NegativeHits != 2 || stats.NegativeItems != 1 {
+		t.Fatalf("%+v", stats)
+	}
+	// the write invalidates the miss
+	service.Put("none", []byte("1"))
+	service.Evict("none")
+	if v, err := service.Get("none"); err != nil || string(v) != "1" {
+		t.Fatalf("get none: %s, %v", v, err)
+	}
+
+	// the miss expires
+	service.Get("gone")
+	time.Sleep(60 * time.Millisecond)
+	loads.Store(0)
+	service.Get("gone")
+	if loads.Load() != 1 {
+		t.Fatalf("the getter is called %d times", loads.Load())
+	}
+
+	// the other errors are not cached
+	loads.Store(0)
+	failing := NewService("negative-error", GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return nil, errors.New("db is down")
+	}), db, byteView, 2<<5, 2, 0, WithNegativeCache(time.Minute, 2<<5))
+	defer failing.Close()
+	failing.Get("a")
+	failing.Get("a")
+	if loads.Load() != 2 {
+		t.Fatalf("the getter is called %d times", loads.Load())
+	}
+}
+
+func TestServiceBloomFilter(t *testing.T) {
+	var loads atomic.Int64
+	db := &Mapper{db: map[string][]byte{"a": []byte("1")}}
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return db.Get(key)
+	})
+	keys := KeyEnumeratorFunc(func() ([]string, error) {
+		return []string{"a"}, nil
+	})
+	service := NewService("bloom", getter, db, byteView, 2<<5, 2, 0, WithBloomFilter(keys, 100, 0.01))
+	if v, err := service.Get("a"); err != nil || string(v) != "1" {
+		t.Fatalf("get a: %s, %v", v, err)
+	}
+	if _, err := service.Get("none"); err != common.ErrKeyNotInDB {
+		t.Fatal(err)
+	}
+	// the written key passes the filter
+	service.Put("b", []byte("2"))
+	service.Evict("b")
+	if v, err := service.Get("b"); err != nil || string(v) != "2" {
+		t.Fatalf("get b: %s, %v", v, err)
+	}
+	if loads.Load() != 2 {
+		t.Fatalf("the getter is called %d times", loads.Load())
+	}
+	if stats := service.Stats(); stats.BloomRejects != 1 {
+		t.Fatalf("%+v", stats)
+	}
+
+	// the filter is disabled if the keys can't be listed
+	service = NewService("bloom-disabled", getter, db, byteView, 2<<5, 2, 0,
+		WithBloomFilter(KeyEnumeratorFunc(func() ([]string, error) {
+			return nil, errors.New("db is down")
+		}), 100, 0.01))
+	if v, err := service.Get("a"); err != nil || string(v) != "1" {
+		t.Fatalf("get a: %s, %v", v, err)
+	}
+}
+
+func TestServiceBloomRebuild(t *testing.T) {
+	db := &Mapper{db: map[string][]byte{"a": []byte("1")}}
+	keys := KeyEnumeratorFunc(func() ([]string, error) {
+		db.RLock()
+		defer db.RUnlock()
+		var keys []string
+		for key := range db.db {
+			keys = append(keys, key)
+		}
+		return keys, nil
+	})
+	service := NewService("bloom-rebuild", db, db, byteView, 2<<5, 2, 0,
+		WithBloomFilter(keys, 100, 0.01), WithBloomRebuild(10*time.Millisecond))
+	defer service.Close()
+	// the migrated key passes the filter
+	service.Populate("b", []byte("2"), 1)
+	service.Evict("b")
+	db.Put("b", []byte("2"))
+	if v, err := service.Get("b"); err != nil || string(v) != "2" {
+		t.Fatalf("get b: %s, %v", v, err)
+	}
+	// the key written bypassing the service passes the rebuilt filter
+	db.Put("c", []byte("3"))
+	if _, err := service.Get("c"); err != common.ErrKeyNotInDB {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		v, err := service.Get("c")
+		if err == nil && string(v) == "3" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("get c: %s, %v", v, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServiceGetContext(t *testing.T) {
+	loadErrs := make(chan error, 2)
+	getter := GetterContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		switch key {
+		case "fast":
+			return []byte(key), nil
+		case "delayed":
+			select {
+			case <-time.After(50 * time.Millisecond):
+				return []byte(key), nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		<-ctx.Done()
+		loadErrs <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	type ctxKey struct{}
+	var putValue any
+	putter := PutterContextFunc(func(ctx context.Context, key string, value []byte) error {
+		putValue = ctx.Value(ctxKey{})
+		return nil
+	})
+	service := NewService("context", getter, putter, byteView, 2<<5, 2, 0, WithTimeout(200*time.Millisecond))
+	if v, err := service.GetContext(context.Background(), "fast"); err != nil || string(v) != "fast" {
+		t.Fatalf("get fast: %s, %v", v, err)
+	}
+
+	// the deadline of the caller bounds its wait, the shared load runs until the timeout of the service
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := service.GetContext(ctx, "slow"); err != common.ErrTimeout || time.Since(start) > 150*time.Millisecond {
+		t.Fatal(err, time.Since(start))
+	}
+	if err := <-loadErrs; err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	// the caller with time left is not timed out by the shorter deadline of the other one
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := service.GetContext(ctx, "delayed")
+		errs <- err
+	}()
+	if v, err := service.GetContext(context.Background(), "delayed"); err != nil || string(v) != "delayed" {
+		t.Fatalf("get delayed: %s, %v", v, err)
+	}
+	if err := <-errs; err != common.ErrTimeout {
+		t.Fatal(err)
+	}
+
+	// the canceled caller returns at once, the shared load runs until its deadline
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := service.GetContext(ctx, "slow-canceled"); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if err := <-loadErrs; err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	service.PutContext(context.WithValue(context.Background(), ctxKey{}, "v"), "a", []byte("1"))
+	if putValue != "v" {
+		t.Fatal(putValue)
+	}
+}
+
+// batchMapper loads many keys in one call
+type batchMapper struct {
+	*Mapper
+	batches atomic.Int32
+}
+
+func (b *batchMapper) GetBatch(keys []string) (map[string][]byte, error) {
+	b.batches.Add(1)
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if value, err := b.Get(key); err == nil {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func TestServiceGetMulti(t *testing.T) {
+	db := &batchMapper{Mapper: &Mapper{db: map[string][]byte{
+		"a": []byte("1"), "b": []byte("2"), "c": []byte("3"),
+	}}}
+	service := NewService("multi", db, db, byteView, 2<<5, 2, 0, WithNegativeCache(time.Minute, 2<<5))
+	service.Get("a")
+	values, err := service.GetMulti([]string{"a", "b", "c", "b", "none"})
+	if err != nil || len(values) != 3 || string(values["a"]) != "1" || string(values["c"]) != "3" {
+		t.Fatal(err, values)
+	}
+	// the misses are loaded in one batch, and cached
+	if db.batches.Load() != 1 {
+		t.Fatalf("%d batches", db.batches.Load())
+	}
+	values, _ = service.GetMulti([]string{"b", "c", "none"})
+	if len(values) != 2 || db.batches.Load() != 1 {
+		t.Fatal(values, db.batches.Load())
+	}
+	if stats := service.Stats(); stats.NegativeHits != 1 || stats.Dedups != 0 {
+		t.Fatalf("%+v", stats)
+	}
+
+	// the getter without GetBatch loads the keys one by one
+	var loads atomic.Int32
+	single := NewService("multi-single", GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		if value, err := db.Get(key); err == nil {
+			return value, nil
+		}
+		return nil, common.ErrKeyNotInDB
+	}), db, byteView, 2<<5, 2, 0)
+	values, err = single.GetMulti([]string{"a", "b", "none"})
+	if err != nil || len(values) != 2 || loads.Load() != 3 {
+		t.Fatal(err, values, loads.Load())
+	}
+}
+
+// batchContextMapper loads many keys in one call until the context is done
+type batchContextMapper struct {
+	*batchMapper
+	delay time.Duration
+}
+
+func (b *batchContextMapper) GetBatchContext(ctx context.Context, keys []string) (map[string][]byte, error) {
+	select {
+	case <-time.After(b.delay):
+		return b.GetBatch(keys)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestServiceGetMultiContext(t *testing.T) {
+	db := &batchContextMapper{batchMapper: &batchMapper{Mapper: &Mapper{db: map[string][]byte{
+		"a": []byte("1"), "b": []byte("2"),
+	}}}}
+	service := NewService("multi-context", db, db, byteView, 2<<5, 2, 0, WithTimeout(time.Second))
+	defer service.Close()
+	values, err := service.GetMultiContext(context.Background(), []string{"a", "none"})
+	if err != nil || len(values) != 1 || string(values["a"]) != "1" || db.batches.Load() != 1 {
+		t.Fatal(err, values, db.batches.Load())
+	}
+
+	// the deadline of the caller bounds the batch load
+	db.delay = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := service.GetMultiContext(ctx, []string{"b"}); err != common.ErrTimeout || time.Since(start) > 500*time.Millisecond {
+		t.Fatal(err, time.Since(start))
+	}
+	if stats := service.Stats(); stats.Timeouts != 1 {
+		t.Fatalf("%+v", stats)
+	}
+}
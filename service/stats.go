@@ -0,0 +1,69 @@
+package service
+
+import (
+	"distributed_cache/cache"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is the snapshot of the service counters
+type Stats struct {
+	Gets       int64 `json:"gets"`
+	Loads      int64 `json:"loads"` // getter calls
+	LoadErrors int64 `json:"load_errors"`
+	// the Gets served by the load in flight of the same key
+	Dedups         int64         `json:"dedups"`
+	Timeouts       int64         `json:"timeouts"`
+	AvgLoadLatency time.Duration `json:"avg_load_latency_ns"`
+	Refreshes      int64         `json:"refreshes"`  // background reloads
+	StaleHits      int64         `json:"stale_hits"` // Gets served by the expired value
+	// the Gets answered by the negative cache, and its cached keys
+	NegativeHits  int64 `json:"negative_hits"`
+	NegativeItems int   `json:"negative_items"`
+	BloomRejects  int64 `json:"bloom_rejects"` // Gets rejected by the bloom filter
+	// the writes not flushed to the putter yet and the failed flushes, in the write-behind mode
+	PendingWrites int64       `json:"pending_writes"`
+	FlushErrors   int64       `json:"flush_errors"`
+	Cache         cache.Stats `json:"cache"`
+}
+
+type counters struct {
+	gets       atomic.Int64
+	flights    atomic.Int64 // the Gets which are not deduplicated
+	loads      atomic.Int64
+	loadErrors atomic.Int64
+	loadNanos  atomic.Int64
+	timeouts   atomic.Int64
+	refreshes  atomic.Int64
+	staleHits  atomic.Int64
+	// the Gets answered by the negative cache
+	negativeHits atomic.Int64
+	bloomRejects atomic.Int64
+}
+
+func (s *Service) Stats() Stats {
+	// read the flights before the gets, so the dedups is never negative
+	flights := s.counters.flights.Load()
+	stats := Stats{
+		Gets:       s.counters.gets.Load(),
+		Loads:      s.counters.loads.Load(),
+		LoadErrors: s.counters.loadErrors.Load(),
+		Timeouts:   s.counters.timeouts.Load(),
+		Refreshes:  s.counters.refreshes.Load(),
+		StaleHits:  s.counters.staleHits.Load(),
+		Cache:      s.cache.Stats(),
+	}
+	stats.Dedups = stats.Gets - flights
+	stats.NegativeHits = s.counters.negativeHits.Load()
+	stats.BloomRejects = s.counters.bloomRejects.Load()
+	if s.negative != nil {
+		stats.NegativeItems = s.negative.cache.Stats().Items
+	}
+	if s.writeBehind != nil {
+		stats.PendingWrites, stats.FlushErrors = s.writeBehind.stats()
+	}
+	if stats.Loads > 0 {
+		stats.AvgLoadLatency = time.Duration(s.counters.loadNanos.Load() / stats.Loads)
+	}
+	return stats
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"distributed_cache/cache"
+	"fmt"
+	"time"
+)
+
+// versionedValue attaches the write version to the cache value,
+// the value loaded by the getter has the version 0
+type versionedValue struct {
+	cache.Value
+	version uint64
+	expire  time.Time // the value is stale after it, zero means never
+}
+
+func (v versionedValue) NBytes() int {
+	// 8 bytes for the version
+	return v.Value.NBytes() + 8
+}
+
+func (v versionedValue) String() string {
+	return fmt.Sprintf("%v(v%d)", v.Value, v.version)
+}
+
+func expireOf(value cache.Value) time.Time {
+	if v, ok := value.(versionedValue); ok {
+		return v.expire
+	}
+	return time.Time{}
+}
+
+func versionOf(value cache.Value) uint64 {
+	if v, ok := value.(versionedValue); ok {
+		return v.version
+	}
+	return 0
+}
+
+// the result of the singleflight call
+type versionedBytes struct {
+	value   []byte
+	version uint64
+}
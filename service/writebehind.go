@@ -0,0 +1,341 @@
+package service
+
+import (
+	"bufio"
+	"distributed_cache/common"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// BatchPutter writes many entries at once,
+// the write-behind flusher prefers it over Putter.Put
+type BatchPutter interface {
+	PutBatch(entries map[string][]byte) error
+}
+
+type WriteBehindConfig struct {
+	FlushInterval time.Duration // flush the pending writes every interval
+	BatchSize     int           // max entries of a batch, flush early once so many keys are pending
+	RetryBackoff  time.Duration // wait after a failed flush, doubled on every failure
+	MaxBackoff    time.Duration
+	// optional append-only file of the pending writes,
+	// they are replayed on start so they survive a crash
+	QueuePath string
+}
+
+var DefaultWriteBehindConfig = WriteBehindConfig{
+	FlushInterval: time.Second,
+	BatchSize:     100,
+	RetryBackoff:  100 * time.Millisecond,
+	MaxBackoff:    30 * time.Second,
+}
+
+// the latest value of the key, seq tells whether it is overwritten during the flush
+type pendingWrite struct {
+	value []byte
+	seq   uint64
+}
+
+// the line of the queue file, delete cancels the pending write of the key
+type queueRecord struct {
+	Key    string `json:"k"`
+	Value  []byte `json:"v,omitempty"`
+	Delete bool   `json:"d,omitempty"`
+}
+
+// writeBehind coalesces the writes per key and flushes them to the putter in background
+type writeBehind struct {
+	config WriteBehindConfig
+	putter Putter
+
+	mu      sync.Mutex
+	pending map[string]pendingWrite
+	seq     uint64
+	queue   *os.File // nil unless the queue is durable
+
+	flushMu sync.Mutex // one flush at a time
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	errors  int64 // failed flushes, guarded by mu
+}
+
+func newWriteBehind(putter Putter, config WriteBehindConfig) (*writeBehind, error) {
+	if config.FlushInterval <= 0 || config.BatchSize <= 0 || config.RetryBackoff <= 0 {
+		return nil, common.ErrPositiveParamNegative
+	}
+	w := &writeBehind{
+		config:  config,
+		putter:  putter,
+		pending: make(map[string]pendingWrite),
+		full:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if config.QueuePath != "" {
+		if err := w.replay(); err != nil {
+			return nil, err
+		}
+	}
+	go w.run()
+	return w, nil
+}
+
+// load the pending writes of the queue file, then compact it
+func (w *writeBehind) replay() error {
+	f, err := os.OpenFile(w.config.QueuePath, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var r queueRecord
+		if err := decoder.Decode(&r); err != nil {
+			// io.EOF, or the last record is torn by the crash
+			break
+		}
+		w.apply(r)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.compact()
+}
+
+func (w *writeBehind) apply(r queueRecord) {
+	if r.Delete {
+		delete(w.pending, r.Key)
+		return
+	}
+	w.seq++
+	w.pending[r.Key] = pendingWrite{value: r.Value, seq: w.seq}
+}
+
+// rewrite the queue file with the pending writes only, called under mu
+func (w *writeBehind) compact() error {
+	tmp := w.config.QueuePath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(f)
+	encoder := json.NewEncoder(buf)
+	for key, p := range w.pending {
+		encoder.Encode(queueRecord{Key: key, Value: p.value})
+	}
+	if err = buf.Flush(); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, w.config.QueuePath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if w.queue != nil {
+		w.queue.Close()
+	}
+	w.queue, err = os.OpenFile(w.config.QueuePath, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// append the record to the queue file, called under mu
+func (w *writeBehind) append(r queueRecord) error {
+	if w.queue == nil {
+		return nil
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.queue.Write(append(line, '\n'))
+	return err
+}
+
+func (w *writeBehind) enqueue(key string, value []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r := queueRecord{Key: key, Value: value}
+	if err := w.append(r); err != nil {
+		return err
+	}
+	w.apply(r)
+	if len(w.pending) >= w.config.BatchSize {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// drop the pending write of the key, the write in flight is not canceled
+func (w *writeBehind) cancel(key string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.pending[key]; !ok {
+		return nil
+	}
+	r := queueRecord{Key: key, Delete: true}
+	if err := w.append(r); err != nil {
+		return err
+	}
+	w.apply(r)
+	return nil
+}
+
+// delete the key through del and drop its pending write once del succeeds,
+// the flush is held so the write in flight can not land after the deletion
+func (w *writeBehind) delete(key string, del func(key string) error) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	if err := del(key); err != nil {
+		return err
+	}
+	return w.cancel(key)
+}
+
+// the pending value is newer than the one in the putter
+func (w *writeBehind) get(key string) ([]byte, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p, ok := w.pending[key]
+	return p.value, ok
+}
+
+// the keys of the pending writes
+func (w *writeBehind) keys() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	keys := make([]string, 0, len(w.pending))
+	for key := range w.pending {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// the pending writes and the failed flushes
+func (w *writeBehind) stats() (int64, int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return int64(len(w.pending)), w.errors
+}
+
+// write the pending entries in batches, the failed ones stay pending
+func (w *writeBehind) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	batch := make(map[string]pendingWrite, len(w.pending))
+	for key, p := range w.pending {
+		batch[key] = p
+	}
+	w.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	written, err := w.write(batch)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range written {
+		// the write during the flush stays pending
+		if p, ok := w.pending[key]; ok && p.seq == batch[key].seq {
+			delete(w.pending, key)
+		}
+	}
+	if err != nil {
+		w.errors++
+	}
+	if w.queue != nil && len(written) > 0 {
+		if cerr := w.compact(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// return the written keys and the last error
+func (w *writeBehind) write(batch map[string]pendingWrite) ([]string, error) {
+	var written []string
+	var err error
+	if batchPutter, ok := w.putter.(BatchPutter); ok {
+		entries := make(map[string][]byte, w.config.BatchSize)
+		send := func() {
+			if perr := batchPutter.PutBatch(entries); perr != nil {
+				err = perr
+			} else {
+				for key := range entries {
+					written = append(written, key)
+				}
+			}
+			entries = make(map[string][]byte, w.config.BatchSize)
+		}
+		for key, p := range batch {
+			entries[key] = p.value
+			if len(entries) == w.config.BatchSize {
+				send()
+			}
+		}
+		if len(entries) > 0 {
+			send()
+		}
+		return written, err
+	}
+	for key, p := range batch {
+		if perr := w.putter.Put(key, p.value); perr != nil {
+			err = perr
+			continue
+		}
+		written = append(written, key)
+	}
+	return written, err
+}
+
+func (w *writeBehind) run() {
+	defer close(w.done)
+	var backoff time.Duration
+	for {
+		wait, full := w.config.FlushInterval, w.full
+		if backoff > 0 {
+			// don't flush early while backing off
+			wait, full = backoff, nil
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(wait):
+		case <-full:
+		}
+		if err := w.flush(); err != nil {
+			backoff = min(max(backoff*2, w.config.RetryBackoff), max(w.config.MaxBackoff, w.config.RetryBackoff))
+		} else {
+			backoff = 0
+		}
+	}
+}
+
+// stop the flusher and flush the pending writes once more,
+// the writes still pending are kept in the queue file
+func (w *writeBehind) close() error {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+	err := w.flush()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.queue != nil {
+		if serr := w.queue.Sync(); err == nil {
+			err = serr
+		}
+		w.queue.Close()
+		w.queue = nil
+	}
+	return err
+}
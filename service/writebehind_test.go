@@ -0,0 +1,279 @@
+package service
+
+import (
+	"distributed_cache/cache"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchStore records the batches, fails the first fails batches
+type batchStore struct {
+	sync.Mutex
+	db      map[string][]byte
+	batches []map[string][]byte
+	fails   int
+}
+
+func newBatchStore() *batchStore {
+	return &batchStore{db: make(map[string][]byte)}
+}
+
+func (b *batchStore) Get(key string) ([]byte, error) {
+	b.Lock()
+	defer b.Unlock()
+	value, ok := b.db[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return value, nil
+}
+
+func (b *batchStore) Put(key string, value []byte) error {
+	return b.PutBatch(map[string][]byte{key: value})
+}
+
+func (b *batchStore) PutBatch(entries map[string][]byte) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.fails > 0 {
+		b.fails--
+		return errors.New("db is down")
+	}
+	b.batches = append(b.batches, entries)
+	for key, value := range entries {
+		b.db[key] = value
+	}
+	return nil
+}
+
+func (b *batchStore) Delete(key string) error {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.db, key)
+	return nil
+}
+
+func (b *batchStore) snapshot() (map[string][]byte, int) {
+	b.Lock()
+	defer b.Unlock()
+	db := make(map[string][]byte, len(b.db))
+	for key, value := range b.db {
+		db[key] = value
+	}
+	return db, len(b.batches)
+}
+
+var byteView = cache.NewValueFunc(func(b []byte) cache.Value {
+	return cache.NewByteView(b)
+})
+
+func TestWriteBehindCoalesce(t *testing.T) {
+	store := newBatchStore()
+	config := DefaultWriteBehindConfig
+	config.FlushInterval = time.Hour
+	service := NewService("write-behind", store, store, byteView, 2<<10, 2, 0, WithWriteBehind(config))
+	service.SetDeleter(store)
+	defer service.Close()
+	for i := 0; i < 3; i++ {
+		service.Put("1", []byte{'a' + byte(i)})
+	}
+	service.Put("2", []byte("b"))
+	service.Put("3", []byte("c"))
+	service.Delete("3")
+	if db, _ := store.snapshot(); len(db) != 0 || service.Stats().PendingWrites != 2 {
+		t.Fatal("the putter must not be called by Put")
+	}
+	if value, err := service.Get("1"); err != nil || string(value) != "c" {
+		t.Fail()
+	}
+	if err := service.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	db, batches := store.snapshot()
+	if batches != 1 || len(db) != 2 || string(db["1"]) != "c" || string(db["2"]) != "b" {
+		t.Fatalf("unexpected db %v after %d batches", db, batches)
+	}
+	if service.Stats().PendingWrites != 0 {
+		t.Fail()
+	}
+}
+
+func TestWriteBehindDeleteWithoutDeleter(t *testing.T) {
+	store := newBatchStore()
+	config := DefaultWriteBehindConfig
+	config.FlushInterval = time.Hour
+	service := NewService("write-behind-no-deleter", store, store, byteView, 2<<10, 2, 0, WithWriteBehind(config))
+	service.Put("1", []byte("a"))
+	// the key is removed from the cache only, the write is still persisted
+	if err := service.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := store.snapshot(); string(db["1"]) != "a" {
+		t.Fatalf("the pending write is lost: %v", db)
+	}
+}
+
+func TestWriteBehindDeleteAfterFlush(t *testing.T) {
+	store := newBatchStore()
+	started, release := make(chan struct{}), make(chan struct{})
+	putter := PutterFunc(func(key string, value []byte) error {
+		close(started)
+		<-release
+		return store.Put(key, value)
+	})
+	config := DefaultWriteBehindConfig
+	config.FlushInterval = time.Hour
+	w, err := newWriteBehind(putter, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	w.enqueue("1", []byte("a"))
+	go w.flush()
+	<-started
+	deleted := make(chan error, 1)
+	go func() {
+		deleted <- w.delete("1", store.Delete)
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("the delete must wait for the write in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := store.snapshot(); len(db) != 0 {
+		t.Fatalf("the write lands after the delete: %v", db)
+	}
+}
+
+func TestWriteBehindBloomRebuild(t *testing.T) {
+	store := newBatchStore()
+	keys := KeyEnumeratorFunc(func() ([]string, error) {
+		db, _ := store.snapshot()
+		var keys []string
+		for key := range db {
+			keys = append(keys, key)
+		}
+		return keys, nil
+	})
+	config := DefaultWriteBehindConfig
+	config.FlushInterval = time.Hour
+	service := NewService("write-behind-bloom", store, store, byteView, 2<<10, 2, 0,
+		WithWriteBehind(config), WithBloomFilter(keys, 100, 0.01))
+	defer service.Close()
+	service.Put("1", []byte("a"))
+	// the pending write is not listed by the rebuild, but kept in the filter
+	service.rebuildBloomFilter(service.bloom)
+	if err := service.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	service.Evict("1")
+	if value, err := service.Get("1"); err != nil || string(value) != "a" {
+		t.Fatal(value, err)
+	}
+}
+
+func TestWriteBehindRetry(t *testing.T) {
+	store := newBatchStore()
+	store.fails = 2
+	service := NewService("write-behind-retry", store, store, byteView, 2<<10, 2, 0, WithWriteBehind(WriteBehindConfig{
+		FlushInterval: 5 * time.Millisecond,
+		BatchSize:     2,
+		RetryBackoff:  5 * time.Millisecond,
+		MaxBackoff:    20 * time.Millisecond,
+	}))
+	defer service.Close()
+	for _, key := range []string{"1", "2", "3"} {
+		service.Put(key, []byte(key))
+	}
+	deadline := time.Now().Add(time.Second)
+	for service.Stats().PendingWrites > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := service.Stats()
+	if db, _ := store.snapshot(); len(db) != 3 || stats.FlushErrors == 0 {
+		t.Fatalf("unexpected db %v, stats %+v", db, stats)
+	}
+}
+
+func TestWriteBehindReadPending(t *testing.T) {
+	store := newBatchStore()
+	store.db["1"] = []byte("old")
+	config := DefaultWriteBehindConfig
+	config.FlushInterval = time.Hour
+	// the cache holds only one entry
+	service := NewService("write-behind-read", store, store, byteView, 20, 2, 0, WithWriteBehind(config))
+	service.Put("1", []byte("new"))
+	service.Put("2", []byte("2"))
+	if _, err := service.cache.Get("1"); err == nil {
+		t.Fatal("the key must be evicted")
+	}
+	// the evicted dirty entry is read from the pending writes
+	if value, err := service.Get("1"); err != nil || string(value) != "new" {
+		t.Fatalf("get %s, %v", value, err)
+	}
+	// flush on close
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := store.snapshot(); string(db["1"]) != "new" || string(db["2"]) != "2" {
+		t.Fail()
+	}
+}
+
+func TestWriteBehindQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	store := newBatchStore()
+	store.fails = 1 << 30
+	config := DefaultWriteBehindConfig
+	config.FlushInterval = time.Hour
+	config.QueuePath = path
+	w, err := newWriteBehind(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.enqueue("1", []byte("a"))
+	w.enqueue("1", []byte("b"))
+	w.enqueue("2", []byte("c"))
+	w.enqueue("3", []byte("d"))
+	w.cancel("3")
+	if w.flush() == nil {
+		t.Fatal("the flush must fail")
+	}
+	// crash without close, the last record is torn
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"k":"4","v":`)
+	f.Close()
+
+	store.fails = 0
+	w, err = newWriteBehind(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := w.get("1"); !ok || string(value) != "b" {
+		t.Fail()
+	}
+	if pending, _ := w.stats(); pending != 2 {
+		t.Fatalf("%d writes are replayed", pending)
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := store.snapshot(); len(db) != 2 || string(db["2"]) != "c" {
+		t.Fail()
+	}
+	// the flushed writes are compacted out of the queue
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Fail()
+	}
+}
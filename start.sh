@@ -1,10 +1,11 @@
 #!/bin/bash
 trap "rm distributed_cache;kill 0" EXIT
 go build -o distributed_cache
-./distributed_cache -port=8001 &
-./distributed_cache -port=8002 &
-./distributed_cache -port=8003 &
-./distributed_cache -port=8004 &
+# cache nodes keep trying to register themselves until the master is up
+./distributed_cache -port=8001 -master=localhost:9999 &
+./distributed_cache -port=8002 -master=localhost:9999 &
+./distributed_cache -port=8003 -master=localhost:9999 &
+./distributed_cache -port=8004 -master=localhost:9999 &
 ./distributed_cache -port=9999 -cache=false
 
 # sleep 2